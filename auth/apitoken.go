@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	apiTokenPrefix  = "todo_"
+	feedTokenPrefix = "feed_"
+)
+
+// NewAPIToken generates a random API token and the hash to store in its
+// place.
+func NewAPIToken() (string, string, error) {
+	return newToken(apiTokenPrefix)
+}
+
+// NewFeedToken generates the secret of a calendar feed URL and its hash.
+// The prefix keeps it from being taken for an API token.
+func NewFeedToken() (string, string, error) {
+	return newToken(feedTokenPrefix)
+}
+
+func newToken(prefix string) (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token := prefix + encode(raw)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the hash an API token is stored and looked up by.
+// The tokens are random, so an unsalted hash is enough.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
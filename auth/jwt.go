@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type Claims struct {
+	Subject string `json:"sub,omitempty"`
+	// Fingerprint identifies the password the token was issued for, see
+	// Fingerprint.
+	Fingerprint string `json:"fp,omitempty"`
+	IssuedAt    int64  `json:"iat"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+var header = encode([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Sign issues an HS256 JSON Web Token with the given claims.
+func Sign(claims Claims, key []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + encode(payload)
+	return unsigned + "." + encode(signature(unsigned, key)), nil
+}
+
+// Parse checks the signature and expiry of a token issued by Sign and
+// returns its claims.
+func Parse(token string, key []byte) (Claims, error) {
+	var claims Claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return claims, ErrInvalidToken
+	}
+
+	sign, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sign, signature(parts[0]+"."+parts[1], key)) {
+		return claims, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// Fingerprint identifies a password, or a password hash, for the claims of
+// a token, so that changing the password invalidates the tokens issued
+// before. It is keyed with the signing key, so a token gives nothing away
+// about the password.
+func Fingerprint(key []byte, password string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("fingerprint:" + password))
+	return encode(mac.Sum(nil)[:16])
+}
+
+// CheckFingerprint reports whether the claims were issued for the password.
+func (c Claims) CheckFingerprint(key []byte, password string) bool {
+	return hmac.Equal([]byte(c.Fingerprint), []byte(Fingerprint(key, password)))
+}
+
+// NewKey returns a random signing key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+func signature(unsigned string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
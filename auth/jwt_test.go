@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	now := time.Now().Unix()
+
+	token, err := Sign(Claims{Subject: "anna", IssuedAt: now, ExpiresAt: now + 60}, key)
+	require.NoError(t, err)
+	claims, err := Parse(token, key)
+	require.NoError(t, err)
+	assert.Equal(t, "anna", claims.Subject)
+
+	parts := strings.Split(token, ".")
+	forged, err := Sign(Claims{Subject: "admin", IssuedAt: now, ExpiresAt: now + 60}, key)
+	require.NoError(t, err)
+	tampered := map[string]string{
+		"signature": parts[0] + "." + parts[1] + "." + encode([]byte("not a signature")),
+		"payload":   parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2],
+		"algorithm": encode([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".",
+		"format":    parts[0] + "." + parts[1],
+	}
+	for name, bad := range tampered {
+		_, err = Parse(bad, key)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	expired, err := Sign(Claims{IssuedAt: now - 120, ExpiresAt: now - 60}, key)
+	require.NoError(t, err)
+	_, err = Parse(expired, key)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	other, err := NewKey()
+	require.NoError(t, err)
+	_, err = Parse(token, other)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestFingerprint(t *testing.T) {
+	key, err := NewKey()
+	require.NoError(t, err)
+	other, err := NewKey()
+	require.NoError(t, err)
+
+	assert.Equal(t, Fingerprint(key, "a"), Fingerprint(key, "a"))
+	assert.NotEqual(t, Fingerprint(key, "a"), Fingerprint(key, "b"))
+	// Without the key the fingerprint cannot be checked against guesses.
+	assert.NotEqual(t, Fingerprint(key, "a"), Fingerprint(other, "a"))
+
+	claims := Claims{Fingerprint: Fingerprint(key, "a")}
+	assert.True(t, claims.CheckFingerprint(key, "a"))
+	assert.False(t, claims.CheckFingerprint(key, "b"))
+	assert.False(t, Claims{}.CheckFingerprint(key, "a"))
+}
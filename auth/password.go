@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Argon2id parameters recommended by OWASP: 19 MiB of memory, two passes
+// and one thread.
+const (
+	memory     = 19 * 1024
+	passes     = 2
+	threads    = 1
+	keyLength  = 32
+	saltLength = 16
+)
+
+// HashPassword returns a salted Argon2id hash of the password in the form
+// "argon2id$v=19$m=memory,t=passes,p=threads$salt$hash".
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, passes, memory, threads, keyLength)
+	return fmt.Sprintf("argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, memory, passes, threads, encode(salt), encode(hash)), nil
+}
+
+// CheckPassword reports whether the password matches a hash made by
+// HashPassword. Hashes in the older form
+// "pbkdf2-sha256$iterations$salt$hash" are still accepted.
+func CheckPassword(password string, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	switch {
+	case len(parts) == 5 && parts[0] == "argon2id":
+		return checkArgon2(password, parts[1:])
+	case len(parts) == 4 && parts[0] == "pbkdf2-sha256":
+		return checkPBKDF2(password, parts[1:])
+	}
+	return false
+}
+
+func checkArgon2(password string, parts []string) bool {
+	var version int
+	var m, t uint32
+	var p uint8
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &m, &t, &p); err != nil || m == 0 || t == 0 || p == 0 {
+		return false
+	}
+	salt, want, ok := decodeHash(parts[2], parts[3])
+	if !ok {
+		return false
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(want)))
+	return subtle.ConstantTimeCompare(hash, want) == 1
+}
+
+func checkPBKDF2(password string, parts []string) bool {
+	rounds, err := strconv.Atoi(parts[0])
+	if err != nil || rounds <= 0 {
+		return false
+	}
+	salt, want, ok := decodeHash(parts[1], parts[2])
+	if !ok {
+		return false
+	}
+
+	hash := pbkdf2.Key([]byte(password), salt, rounds, len(want), sha256.New)
+	return subtle.ConstantTimeCompare(hash, want) == 1
+}
+
+func decodeHash(salt, hash string) ([]byte, []byte, bool) {
+	s, err := base64.RawURLEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, nil, false
+	}
+	h, err := base64.RawURLEncoding.DecodeString(hash)
+	if err != nil || len(h) == 0 {
+		return nil, nil, false
+	}
+	return s, h, true
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("секрет")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "argon2id$v=19$m=19456,t=2,p=1$"), hash)
+	assert.True(t, CheckPassword("секрет", hash))
+	assert.False(t, CheckPassword("Секрет", hash))
+
+	// Each hash has its own salt.
+	other, err := HashPassword("секрет")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	for _, bad := range []string{
+		"",
+		"секрет",
+		"bcrypt$100000$c2FsdA$aGFzaA",
+		"argon2id$v=16$m=19456,t=2,p=1$c2FsdA$aGFzaA",
+		"argon2id$v=19$m=19456,t=0,p=1$c2FsdA$aGFzaA",
+		"argon2id$v=19$m=19456,t=2,p=0$c2FsdA$aGFzaA",
+		"argon2id$v=19$m=19456,t=2,p=1$!!$aGFzaA",
+		"argon2id$v=19$m=19456,t=2,p=1$c2FsdA$",
+		"pbkdf2-sha256$0$c2FsdA$aGFzaA",
+		"pbkdf2-sha256$много$c2FsdA$aGFzaA",
+		"pbkdf2-sha256$1$!!$aGFzaA",
+	} {
+		assert.False(t, CheckPassword("секрет", bad), bad)
+	}
+}
+
+// Accounts created before the switch to Argon2id keep their PBKDF2-SHA256
+// hashes. This one was made with Python's hashlib.pbkdf2_hmac.
+func TestLegacyPassword(t *testing.T) {
+	hash := "pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$2PlcFAcQhY3EGvRIj_Y31ji_ESmEjzVtkhC1b4UMstg"
+	assert.True(t, CheckPassword("секрет", hash))
+	assert.False(t, CheckPassword("секрет!", hash))
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"final/daterules"
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+func (t TaskContainer) AddAPIToken(token daterules.APIToken, hash string) (int64, error) {
+	AddAPIToken := `INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at)
+	VALUES (?, ?, ?, ?, ?)`
+	result, err := t.conn().Exec(AddAPIToken,
+		t.userID,
+		token.Name,
+		hash,
+		strings.Join(token.Scopes, ","),
+		timestamp())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (t TaskContainer) GetAPITokens() ([]daterules.APIToken, error) {
+	tokens := []daterules.APIToken{}
+	GetAPITokens := `SELECT id, name, scopes, created_at, coalesce(last_used_at, '')
+	FROM api_tokens WHERE user_id = ?
+	ORDER BY id ASC`
+	rows, err := t.conn().Query(GetAPITokens, t.userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var token daterules.APIToken
+		var scopes string
+		err := rows.Scan(&token.ID, &token.Name, &scopes, &token.CreatedAt, &token.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (t TaskContainer) DeleteAPIToken(id string) error {
+	result, err := t.conn().Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong token id")
+	}
+
+	return nil
+}
+
+// UseAPIToken finds the token with the given hash and marks it as used
+// now. It returns the token together with its user, whose login is empty
+// for the zero user.
+func (t TaskContainer) UseAPIToken(hash string) (daterules.APIToken, daterules.User, error) {
+	var token daterules.APIToken
+	var user daterules.User
+	var scopes string
+
+	err := t.InTx(func(tx TaskContainer) error {
+		UseAPIToken := `SELECT k.id, k.name, k.scopes, k.created_at, k.user_id, coalesce(u.login, '')
+		FROM api_tokens k LEFT JOIN users u ON u.id = k.user_id
+		WHERE k.token_hash = ?`
+		err := tx.conn().QueryRow(UseAPIToken, hash).Scan(&token.ID, &token.Name, &scopes,
+			&token.CreatedAt, &user.ID, &user.Login)
+		if err != nil {
+			return err
+		}
+		token.LastUsedAt = timestamp()
+		_, err = tx.conn().Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`,
+			token.LastUsedAt, token.ID)
+		return err
+	})
+	token.Scopes = strings.Split(scopes, ",")
+
+	return token, user, err
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"final/daterules"
+)
+
+const (
+	AuditAdd     = "add"
+	AuditEdit    = "edit"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+	AuditMove    = "move"
+	AuditDone    = "done"
+)
+
+type AuditFilter struct {
+	TaskID    string
+	Actor     string
+	Operation string
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Offset    int
+}
+
+// audited runs change in a transaction together with the audit entry
+// describing the task before and after it.
+func (t TaskContainer) audited(operation string, id string, change func(tx TaskContainer) error) error {
+	return t.InTx(func(tx TaskContainer) error {
+		before, err := tx.snapshot(id)
+		if err != nil {
+			return err
+		}
+		if err = change(tx); err != nil {
+			return err
+		}
+		return tx.addAudit(operation, id, before)
+	})
+}
+
+func (t TaskContainer) addAudit(operation string, id string, before []byte) error {
+	after, err := t.snapshot(id)
+	if err != nil {
+		return err
+	}
+
+	AddAudit := `INSERT INTO audit (created_at, user_id, actor, operation, task_id, before, after)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = t.conn().Exec(AddAudit,
+		timestamp(),
+		t.userID,
+		t.actor,
+		operation,
+		rowID(id),
+		nullJSON(before),
+		nullJSON(after))
+
+	return err
+}
+
+// snapshot returns the JSON state of the task, or nil if there is no such row.
+func (t TaskContainer) snapshot(id string) ([]byte, error) {
+	row := t.conn().QueryRow(`SELECT `+taskFields+` FROM scheduler WHERE id = ? AND user_id = ?`,
+		id, t.userID)
+	task, err := scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(task)
+}
+
+func nullJSON(data []byte) sql.NullString {
+	return sql.NullString{String: string(data), Valid: data != nil}
+}
+
+// GetAudit returns a page of the audit log, newest first, and the total
+// number of entries matching the filter.
+func (t TaskContainer) GetAudit(filter AuditFilter) ([]daterules.AuditEntry, int, error) {
+	entries := []daterules.AuditEntry{}
+	where, args := filter.where(t.userID)
+
+	var total int
+	err := t.conn().QueryRow(`SELECT count(*) FROM audit WHERE 1 = 1`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if filter.Limit <= 0 || filter.Limit > limit {
+		filter.Limit = limit
+	}
+	GetAudit := `SELECT id, created_at, actor, operation, task_id, before, after
+	FROM audit WHERE 1 = 1` + where + `
+	ORDER BY id DESC
+	LIMIT ? OFFSET ?`
+	rows, err := t.conn().Query(GetAudit, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry daterules.AuditEntry
+		var before, after sql.NullString
+		err := rows.Scan(&entry.ID, &entry.CreatedAt, &entry.Actor, &entry.Operation,
+			&entry.TaskID, &before, &after)
+		if err != nil {
+			return nil, 0, err
+		}
+		if before.Valid {
+			entry.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			entry.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+func (f AuditFilter) where(userID int64) (string, []any) {
+	where := " AND user_id = ?"
+	args := []any{userID}
+
+	if f.TaskID != "" {
+		where += " AND task_id = ?"
+		args = append(args, rowID(f.TaskID))
+	}
+	if f.Actor != "" {
+		where += " AND actor = ?"
+		args = append(args, f.Actor)
+	}
+	if f.Operation != "" {
+		where += " AND operation = ?"
+		args = append(args, f.Operation)
+	}
+	if !f.From.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, f.From.UTC().Format(time.RFC3339))
+	}
+	if !f.To.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, f.To.UTC().Format(time.RFC3339))
+	}
+	return where, args
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"errors"
+
+	"final/daterules"
+)
+
+func (t TaskContainer) AddCalendarFeed(feed daterules.CalendarFeed, hash string) (int64, error) {
+	AddCalendarFeed := `INSERT INTO calendar_feeds (user_id, name, token_hash, created_at)
+	VALUES (?, ?, ?, ?)`
+	result, err := t.conn().Exec(AddCalendarFeed, t.userID, feed.Name, hash, timestamp())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (t TaskContainer) GetCalendarFeeds() ([]daterules.CalendarFeed, error) {
+	feeds := []daterules.CalendarFeed{}
+	GetCalendarFeeds := `SELECT id, name, created_at, coalesce(last_used_at, '')
+	FROM calendar_feeds WHERE user_id = ?
+	ORDER BY id ASC`
+	rows, err := t.conn().Query(GetCalendarFeeds, t.userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feed daterules.CalendarFeed
+		if err = rows.Scan(&feed.ID, &feed.Name, &feed.CreatedAt, &feed.LastUsedAt); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return feeds, nil
+}
+
+// GetCalendarEntries returns every task the user can see, past ones included
+// and without the page limit, for the calendar feed.
+func (t TaskContainer) GetCalendarEntries() ([]daterules.Task, error) {
+	return t.queryTasks(`SELECT `+taskFields+` FROM scheduler
+	WHERE deleted_at IS NULL AND `+shared+`
+	ORDER BY date, id`, t.userID, t.userID)
+}
+
+func (t TaskContainer) DeleteCalendarFeed(id string) error {
+	result, err := t.conn().Exec(`DELETE FROM calendar_feeds WHERE id = ? AND user_id = ?`, id, t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong feed id")
+	}
+
+	return nil
+}
+
+// UseCalendarFeed finds the feed with the given token hash and marks it as
+// used now. It returns the feed and the id of its user.
+func (t TaskContainer) UseCalendarFeed(hash string) (daterules.CalendarFeed, int64, error) {
+	var feed daterules.CalendarFeed
+	var userID int64
+
+	err := t.InTx(func(tx TaskContainer) error {
+		err := tx.conn().QueryRow(`SELECT id, name, created_at, user_id FROM calendar_feeds WHERE token_hash = ?`,
+			hash).Scan(&feed.ID, &feed.Name, &feed.CreatedAt, &userID)
+		if err != nil {
+			return err
+		}
+		feed.LastUsedAt = timestamp()
+		_, err = tx.conn().Exec(`UPDATE calendar_feeds SET last_used_at = ? WHERE id = ?`,
+			feed.LastUsedAt, feed.ID)
+		return err
+	})
+
+	return feed, userID, err
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"errors"
+
+	"final/daterules"
+)
+
+// ownTask limits a query on a table with a task_id column to the tasks of
+// the user given by the next argument.
+const ownTask = `task_id IN (SELECT id FROM scheduler WHERE user_id = ?)`
+
+func (t TaskContainer) AddChecklistItem(item daterules.ChecklistItem) (int64, error) {
+	AddChecklistItem := `INSERT INTO checklist (task_id, position, title)
+	SELECT s.id,
+		(SELECT coalesce(max(c.position), 0) + 1 FROM checklist c WHERE c.task_id = s.id),
+		?
+	FROM scheduler s WHERE s.id = ? AND s.user_id = ? AND s.deleted_at IS NULL`
+	result, err := t.conn().Exec(AddChecklistItem, item.Title, item.TaskID, t.userID)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, errors.New("wrong row id")
+	}
+
+	return result.LastInsertId()
+}
+
+func (t TaskContainer) GetChecklist(taskID string) ([]daterules.ChecklistItem, error) {
+	items := []daterules.ChecklistItem{}
+	GetChecklist := `SELECT id, task_id, title, done, position
+	FROM checklist WHERE task_id = ? AND ` + ownTask + `
+	ORDER BY position ASC, id ASC`
+	rows, err := t.conn().Query(GetChecklist, taskID, t.userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item daterules.ChecklistItem
+		err := rows.Scan(&item.ID, &item.TaskID, &item.Title, &item.Done, &item.Position)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (t TaskContainer) ToggleChecklistItem(id string) error {
+	ToggleChecklistItem := `UPDATE checklist SET done = 1 - done WHERE id = ? AND ` + ownTask
+	result, err := t.conn().Exec(ToggleChecklistItem, id, t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong item id")
+	}
+
+	return nil
+}
+
+func (t TaskContainer) DeleteChecklistItem(id string) error {
+	result, err := t.conn().Exec(`DELETE FROM checklist WHERE id = ? AND `+ownTask, id, t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong item id")
+	}
+
+	return nil
+}
+
+// ReorderChecklist sets the order of a task's checklist to the order of
+// ids, which must list every item of the task exactly once.
+func (t TaskContainer) ReorderChecklist(taskID string, ids []string) error {
+	items, err := t.GetChecklist(taskID)
+	if err != nil {
+		return err
+	}
+	if len(items) != len(ids) {
+		return errors.New("checklist items mismatch")
+	}
+
+	return t.InTx(func(tx TaskContainer) error {
+		seen := make(map[string]bool, len(ids))
+		for i, id := range ids {
+			if seen[id] {
+				return errors.New("checklist items mismatch")
+			}
+			seen[id] = true
+
+			result, err := tx.conn().Exec(`UPDATE checklist SET position = ? WHERE id = ? AND task_id = ?`,
+				i+1, id, taskID)
+			if err != nil {
+				return err
+			}
+			count, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				return errors.New("checklist items mismatch")
+			}
+		}
+		return nil
+	})
+}
+
+func (t TaskContainer) ResetChecklist(taskID string) error {
+	_, err := t.conn().Exec(`UPDATE checklist SET done = 0 WHERE task_id = ? AND `+ownTask,
+		taskID, t.userID)
+	return err
+}
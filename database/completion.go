@@ -0,0 +1,124 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"final/daterules"
+)
+
+// CompleteEntry hides a finished one-off task. Unlike a deleted task it
+// stays out of the trash; the row is only kept so that the completion can
+// be undone, see ReopenEntry and PurgeCompleted.
+func (t TaskContainer) CompleteEntry(id string) error {
+	CompleteEntry := `UPDATE scheduler SET deleted_at = ?, done_at = ?, updated_at = ?, version = version + 1 
+	WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	return t.audited(AuditDone, id, func(tx TaskContainer) error {
+		now := timestamp()
+		result, err := tx.conn().Exec(CompleteEntry, now, now, now, id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong row id")
+		}
+		return nil
+	})
+}
+
+// ReopenEntry brings back a task hidden by CompleteEntry.
+func (t TaskContainer) ReopenEntry(id string) error {
+	ReopenEntry := `UPDATE scheduler SET deleted_at = NULL, done_at = NULL, updated_at = ?, version = version + 1 
+	WHERE id = ? AND user_id = ? AND done_at IS NOT NULL`
+	return t.audited(AuditRestore, id, func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(ReopenEntry, timestamp(), id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong row id")
+		}
+		return nil
+	})
+}
+
+// PurgeCompleted removes the tasks of all users completed before the given
+// time, when their completion can no longer be undone.
+func (t TaskContainer) PurgeCompleted(before time.Time) error {
+	_, err := t.purge(`DELETE FROM scheduler WHERE done_at < ?`, before.UTC().Format(time.RFC3339))
+	return err
+}
+
+func (t TaskContainer) AddCompletion(completion daterules.Completion) (int64, error) {
+	AddCompletion := `INSERT INTO completions (user_id, task_id, title, date, next_date, done_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+	result, err := t.conn().Exec(AddCompletion,
+		t.userID,
+		rowID(completion.TaskID),
+		completion.Title,
+		completion.Date,
+		completion.NextDate,
+		completion.DoneAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (t TaskContainer) DeleteCompletion(id string) error {
+	_, err := t.conn().Exec(`DELETE FROM completions WHERE id = ? AND user_id = ?`, id, t.userID)
+	return err
+}
+
+// GetCompletions returns the completions of a task by the user and, for
+// the tasks the user owns or shares, by anyone else.
+func (t TaskContainer) GetCompletions(taskID string) ([]daterules.Completion, error) {
+	GetCompletions := `SELECT id, task_id, title, date, next_date, done_at
+	FROM completions WHERE task_id = ?
+	AND (user_id = ? OR task_id IN (SELECT id FROM scheduler WHERE ` + shared + `))
+	ORDER BY done_at DESC, id DESC
+	LIMIT ?`
+	return t.queryCompletions(GetCompletions, rowID(taskID), t.userID, t.userID, t.userID, limit)
+}
+
+func (t TaskContainer) RecentCompletions(count int) ([]daterules.Completion, error) {
+	if count <= 0 || count > limit {
+		count = limit
+	}
+	RecentCompletions := `SELECT id, task_id, title, date, next_date, done_at
+	FROM completions WHERE user_id = ?
+	ORDER BY done_at DESC, id DESC
+	LIMIT ?`
+	return t.queryCompletions(RecentCompletions, t.userID, count)
+}
+
+func (t TaskContainer) queryCompletions(query string, args ...any) ([]daterules.Completion, error) {
+	completions := []daterules.Completion{}
+	rows, err := t.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c daterules.Completion
+		err := rows.Scan(&c.ID, &c.TaskID, &c.Title, &c.Date, &c.NextDate, &c.DoneAt)
+		if err != nil {
+			return nil, err
+		}
+		completions = append(completions, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return completions, nil
+}
@@ -6,6 +6,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"final/daterules"
 
@@ -18,13 +21,118 @@ const (
 )
 
 type TaskContainer struct {
-	db *sql.DB
+	db     *sql.DB
+	tx     *sql.Tx
+	actor  string
+	userID int64
+}
+
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+var ErrVersionConflict = errors.New("task version conflict")
+
+var ErrNotFound = errors.New("task not found")
+
+type Filter struct {
+	ProjectID  string
+	Actionable bool
+	Sort       string
+	Desc       bool
+}
+
+var sortColumns = map[string]string{
+	"":           "date",
+	"date":       "date",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// ErrWrongSort is returned for a sort key not listed in sortColumns.
+var ErrWrongSort = errors.New("wrong sort key")
+
+// ErrWrongProject is returned for a project id that is not a number.
+var ErrWrongProject = errors.New("wrong project id")
+
+type scanner interface {
+	Scan(dest ...any) error
 }
 
 func NewContainer(db *sql.DB) TaskContainer {
 	return TaskContainer{db: db}
 }
 
+func (t TaskContainer) conn() querier {
+	if t.tx != nil {
+		return t.tx
+	}
+	return t.db
+}
+
+// InTx runs fn against a container bound to a single transaction, which
+// is committed if fn succeeds and rolled back otherwise. Nested calls
+// share the outer transaction and only undo their own changes on failure.
+func (t TaskContainer) InTx(fn func(tx TaskContainer) error) error {
+	if t.tx != nil {
+		return t.savepoint(fn)
+	}
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	bound := t
+	bound.tx = tx
+	if err = fn(bound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// savepoint runs fn inside the current transaction. SQLite resolves
+// savepoints of the same name to the innermost one, so nesting is safe.
+func (t TaskContainer) savepoint(fn func(tx TaskContainer) error) error {
+	if _, err := t.tx.Exec("SAVEPOINT nested"); err != nil {
+		return err
+	}
+	if err := fn(t); err != nil {
+		if _, rollbackErr := t.tx.Exec("ROLLBACK TO nested"); rollbackErr != nil {
+			return rollbackErr
+		}
+		_, _ = t.tx.Exec("RELEASE nested")
+		return err
+	}
+	_, err := t.tx.Exec("RELEASE nested")
+	return err
+}
+
+// ForUser returns a container that only sees and changes the rows owned
+// by the user. User 0 owns the tasks created without signing in.
+func (t TaskContainer) ForUser(userID int64) TaskContainer {
+	t.userID = userID
+	return t
+}
+
+// WithActor returns a container that records actor as the author of the
+// changes in the audit log.
+func (t TaskContainer) WithActor(actor string) TaskContainer {
+	t.actor = actor
+	return t
+}
+
+// Open opens the SQLite database in the file at path. Transactions take
+// the write lock when they begin: a transaction that reads before it writes
+// cannot upgrade its lock while another one writes, and would fail instead
+// of waiting for it.
+func Open(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", path+"?_txlock=immediate")
+}
+
 func DBInit() *sql.DB {
 	appPath, err := os.Executable()
 	if err != nil {
@@ -38,44 +146,43 @@ func DBInit() *sql.DB {
 		install = true
 	}
 
-	db, err := sql.Open("sqlite3", "./scheduler.db")
+	db, err := Open("./scheduler.db")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
+	if err = Migrate(db); err != nil {
+		panic(err)
+	}
 	if install {
-		query := ` 
-		CREATE TABLE IF NOT EXISTS scheduler (
-            id INTEGER PRIMARY KEY AUTOINCREMENT, 
-            date TEXT NOT NULL, 
-            title TEXT NOT NULL, 
-            comment TEXT, 
-            repeat TEXT NOT NULL CHECK(length(repeat) <= 128)
-        );
-        CREATE INDEX IF NOT EXISTS idx_date ON scheduler (date);
-		`
-		_, err = db.Exec(query)
-		if err != nil {
-			panic(err)
-		}
 		log.Println("Database creation success!")
 	}
 	return db
 }
 
 func (t TaskContainer) AddEntry(task daterules.Task) (int64, error) {
-	AddEntry := `INSERT INTO scheduler (date, title, comment, repeat) 
-	VALUES (:date, :title, :comment, :repeat)`
-	result, err := t.db.Exec(AddEntry,
-		sql.Named("date", task.Date),
-		sql.Named("title", task.Title),
-		sql.Named("comment", task.Comment),
-		sql.Named("repeat", task.Repeat))
-	if err != nil {
-		return 0, err
-	}
-	idb, err := result.LastInsertId()
+	AddEntry := `INSERT INTO scheduler (date, title, comment, repeat, project_id, created_at, updated_at, user_id) 
+	VALUES (:date, :title, :comment, :repeat, :project_id, :now, :now, :user_id)`
+	var idb int64
+	err := t.InTx(func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(AddEntry,
+			sql.Named("date", task.Date),
+			sql.Named("title", task.Title),
+			sql.Named("comment", task.Comment),
+			sql.Named("repeat", task.Repeat),
+			sql.Named("project_id", rowID(task.ProjectID)),
+			sql.Named("now", timestamp()),
+			sql.Named("user_id", tx.userID))
+		if err != nil {
+			return err
+		}
+		idb, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		return tx.addAudit(AuditAdd, strconv.FormatInt(idb, 10), nil)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -83,78 +190,135 @@ func (t TaskContainer) AddEntry(task daterules.Task) (int64, error) {
 	return idb, nil
 }
 
+// DeleteEntry moves the task to the trash, see PurgeEntry for removing
+// it permanently.
 func (t TaskContainer) DeleteEntry(id string) error {
-	DeleteEntry := `DELETE FROM scheduler WHERE id = ?`
-	result, err := t.db.Exec(DeleteEntry, id)
-	if err != nil {
-		return err
-	}
-	count, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if count == 0 {
-		return errors.New("wrong row id")
-	}
-
-	return nil
+	DeleteEntry := `UPDATE scheduler SET deleted_at = ?, updated_at = ?, version = version + 1 
+	WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	return t.audited(AuditDelete, id, func(tx TaskContainer) error {
+		now := timestamp()
+		result, err := tx.conn().Exec(DeleteEntry, now, now, id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong row id")
+		}
+		return nil
+	})
 }
 
 func (t TaskContainer) EditEntry(task daterules.Task) error {
 	EditEntry := `UPDATE scheduler 
-	SET date = ?, title = ?, comment = ?, repeat = ? 
-	WHERE id = ?;
+	SET date = ?, title = ?, comment = ?, repeat = ?, updated_at = ?, version = version + 1 
+	WHERE id = ? AND user_id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?);
 	`
-	result, err := t.db.Exec(EditEntry,
-		task.Date,
-		task.Title,
-		task.Comment,
-		task.Repeat,
-		task.ID)
-	if err != nil {
-		return err
-	}
-	count, err := result.RowsAffected()
+	return t.audited(AuditEdit, task.ID, func(tx TaskContainer) error {
+		if task.Repeat != "" {
+			blocks, err := tx.blocksOthers(task.ID)
+			if err != nil {
+				return err
+			}
+			if blocks {
+				return ErrRepeatingBlocker
+			}
+		}
+		result, err := tx.conn().Exec(EditEntry,
+			task.Date,
+			task.Title,
+			task.Comment,
+			task.Repeat,
+			timestamp(),
+			task.ID,
+			tx.userID,
+			task.Version,
+			task.Version)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 && task.Version != 0 {
+			if err := tx.CheckVersion(task.ID, task.Version); err != nil {
+				return err
+			}
+		}
+		if count == 0 {
+			return errors.New("wrong row id")
+		}
+		return nil
+	})
+}
+
+// CheckVersion reports ErrVersionConflict if the task has been changed
+// since the given version was read.
+func (t TaskContainer) CheckVersion(id string, version int) error {
+	var current int
+	err := t.conn().QueryRow(`SELECT version FROM scheduler 
+	WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+		id, t.userID).Scan(&current)
 	if err != nil {
-		return err
-	}
-	if count == 0 {
 		return errors.New("wrong row id")
 	}
+	if current != version {
+		return ErrVersionConflict
+	}
 
 	return nil
 }
 
-func (t TaskContainer) GetEntry(id string) (*sql.Row, error) {
-	GetEntry := `SELECT id, date, title, comment, repeat 
-	FROM scheduler WHERE id = ?`
-	row := t.db.QueryRow(GetEntry, id)
-	var entry daterules.Task
-	err := row.Scan(&entry.ID, &entry.Date, &entry.Title, &entry.Comment, &entry.Repeat)
-	if err != nil {
-		panic(err)
+// StoredVersion returns the version of the task, including a task in the
+// trash or completed. It returns ErrNotFound for a task that is gone.
+func (t TaskContainer) StoredVersion(id string) (int, error) {
+	var version int
+	err := t.conn().QueryRow(`SELECT version FROM scheduler WHERE id = ? AND user_id = ?`,
+		id, t.userID).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return version, err
+}
+
+// GetEntry returns ErrNotFound for a task that does not exist, is in the
+// trash or belongs to another user.
+func (t TaskContainer) GetEntry(id string) (daterules.Task, error) {
+	GetEntry := `SELECT ` + taskFields + ` 
+	FROM scheduler WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	task, err := scanTask(t.conn().QueryRow(GetEntry, id, t.userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return task, ErrNotFound
 	}
 
-	return row, nil
+	return task, err
 }
 
-func (t TaskContainer) GetAllEntries() ([]daterules.Task, error) {
-	var entries []daterules.Task
-	GetAllEntries := `SELECT id, date, title, comment, repeat 
+func (t TaskContainer) GetAllEntries(filter Filter) ([]daterules.Task, error) {
+	where, args := filter.where(t.userID)
+	GetAllEntries := `SELECT ` + taskFields + ` 
 	FROM scheduler 
-	WHERE date >= strftime('%Y %m %d', 'now') 
-	ORDER BY date ASC 
+	WHERE date >= strftime('%Y %m %d', 'now')` + where + ` 
+	ORDER BY ` + filter.order() + ` 
 	LIMIT ?
 	`
-	rows, err := t.db.Query(GetAllEntries, limit)
+	return t.queryTasks(GetAllEntries, append(args, limit)...)
+}
+
+func (t TaskContainer) queryTasks(query string, args ...any) ([]daterules.Task, error) {
+	var entries []daterules.Task
+	rows, err := t.conn().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var task daterules.Task
-		err := rows.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, errors.New("database error")
 		}
@@ -166,11 +330,111 @@ func (t TaskContainer) GetAllEntries() ([]daterules.Task, error) {
 	return entries, nil
 }
 
-func (t TaskContainer) CountEntries() (int, error) {
+func (t TaskContainer) CountEntries(filter Filter) (int, error) {
 	var count int64
 
-	row := t.db.QueryRow("SELECT count(*) FROM scheduler")
+	where, args := filter.where(t.userID)
+	row := t.conn().QueryRow("SELECT count(*) FROM scheduler WHERE 1 = 1"+where, args...)
 	_ = row.Scan(&count)
 
 	return int(count), nil
 }
+
+func (t TaskContainer) MoveEntry(id string, projectID string) error {
+	MoveEntry := `UPDATE scheduler SET project_id = ?, updated_at = ? 
+	WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+	return t.audited(AuditMove, id, func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(MoveEntry, rowID(projectID), timestamp(), id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong row id")
+		}
+		return nil
+	})
+}
+
+// blockers joins the existing tasks the current scheduler row depends on.
+const blockers = `FROM dependencies d
+	JOIN scheduler b ON b.id = d.depends_on AND b.deleted_at IS NULL
+	WHERE d.task_id = scheduler.id`
+
+const taskFields = `id, date, title, comment, repeat, project_id,
+	(SELECT group_concat(d.depends_on) ` + blockers + `), deleted_at, version,
+	coalesce(created_at, ''), coalesce(updated_at, '')`
+
+func scanTask(row scanner) (daterules.Task, error) {
+	var task daterules.Task
+	var projectID int64
+	var blockedBy, deletedAt sql.NullString
+	err := row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat,
+		&projectID, &blockedBy, &deletedAt, &task.Version, &task.CreatedAt, &task.UpdatedAt)
+	if err != nil {
+		return task, err
+	}
+	task.DeletedAt = deletedAt.String
+	if projectID != 0 {
+		task.ProjectID = strconv.FormatInt(projectID, 10)
+	}
+	if blockedBy.Valid {
+		task.BlockedBy = strings.Split(blockedBy.String, ",")
+	}
+	return task, nil
+}
+
+func (f Filter) where(userID int64) (string, []any) {
+	where := " AND deleted_at IS NULL AND " + shared
+	args := []any{userID, userID}
+
+	if f.ProjectID != "" {
+		where += " AND project_id = ?"
+		args = append(args, rowID(f.ProjectID))
+	}
+	if f.Actionable {
+		where += " AND NOT EXISTS (SELECT 1 " + blockers + ")"
+	}
+	return where, args
+}
+
+func (f Filter) order() string {
+	column, ok := sortColumns[f.Sort]
+	if !ok {
+		column = "date"
+	}
+	if f.Desc {
+		return column + " DESC, id DESC"
+	}
+	return column + " ASC, id ASC"
+}
+
+// Valid reports whether the filter can be used for a query.
+func (f Filter) Valid() error {
+	if _, ok := sortColumns[f.Sort]; !ok {
+		return ErrWrongSort
+	}
+	if f.ProjectID != "" {
+		if _, err := strconv.ParseInt(f.ProjectID, 10, 64); err != nil {
+			return ErrWrongProject
+		}
+	}
+	return nil
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// rowID converts an optional string id into the integer stored in
+// reference columns, where 0 means "not set".
+func rowID(id string) int64 {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+)
+
+var (
+	ErrSelfDependency = errors.New("task cannot depend on itself")
+	ErrDependencyLoop = errors.New("dependency cycle")
+	// ErrRepeatingBlocker is returned when a repeating task would block
+	// others: it moves to its next date instead of being finished, so the
+	// tasks waiting for it would never be unblocked.
+	ErrRepeatingBlocker = errors.New("repeating task cannot block others")
+)
+
+// AddDependency makes the task taskID wait for the task dependsOn.
+// Dependencies that would close a cycle are rejected.
+func (t TaskContainer) AddDependency(taskID string, dependsOn string) error {
+	task, blocker := rowID(taskID), rowID(dependsOn)
+	if task == blocker {
+		return ErrSelfDependency
+	}
+
+	return t.InTx(func(tx TaskContainer) error {
+		var count int
+		err := tx.conn().QueryRow(`SELECT count(*) FROM scheduler 
+			WHERE id IN (?, ?) AND user_id = ? AND deleted_at IS NULL`,
+			task, blocker, tx.userID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count != 2 {
+			return errors.New("wrong row id")
+		}
+
+		var repeat string
+		err = tx.conn().QueryRow(`SELECT repeat FROM scheduler WHERE id = ?`, blocker).Scan(&repeat)
+		if err != nil {
+			return err
+		}
+		if repeat != "" {
+			return ErrRepeatingBlocker
+		}
+
+		Reachable := `WITH RECURSIVE chain(id) AS (
+			SELECT ?
+			UNION
+			SELECT d.depends_on FROM dependencies d JOIN chain c ON d.task_id = c.id
+		)
+		SELECT count(*) FROM chain WHERE id = ?`
+		err = tx.conn().QueryRow(Reachable, blocker, task).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDependencyLoop
+		}
+
+		_, err = tx.conn().Exec(`INSERT OR IGNORE INTO dependencies (task_id, depends_on) VALUES (?, ?)`,
+			task, blocker)
+		return err
+	})
+}
+
+func (t TaskContainer) DeleteDependency(taskID string, dependsOn string) error {
+	DeleteDependency := `DELETE FROM dependencies WHERE task_id = ? AND depends_on = ? AND ` + ownTask
+	result, err := t.conn().Exec(DeleteDependency, rowID(taskID), rowID(dependsOn), t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong dependency")
+	}
+
+	return nil
+}
+
+// Blockers returns the ids of the unfinished tasks the task waits for.
+func (t TaskContainer) Blockers(id string) ([]string, error) {
+	blockedBy := []string{}
+	rows, err := t.conn().Query(`SELECT d.depends_on FROM dependencies d
+		JOIN scheduler b ON b.id = d.depends_on AND b.deleted_at IS NULL
+		WHERE d.task_id = ? AND b.user_id = ?
+		ORDER BY d.depends_on`, rowID(id), t.userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var blocker string
+		if err := rows.Scan(&blocker); err != nil {
+			return nil, err
+		}
+		blockedBy = append(blockedBy, blocker)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return blockedBy, nil
+}
+
+// blocksOthers reports whether other tasks depend on the task.
+func (t TaskContainer) blocksOthers(id string) (bool, error) {
+	var count int
+	err := t.conn().QueryRow(`SELECT count(*) FROM dependencies WHERE depends_on = ?`, rowID(id)).Scan(&count)
+	return count > 0, err
+}
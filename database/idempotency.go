@@ -0,0 +1,48 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"final/daterules"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrKeyTaken = errors.New("idempotency key is already taken")
+
+func (t TaskContainer) AddIdempotentResponse(resp daterules.IdempotentResponse) error {
+	AddIdempotentResponse := `INSERT INTO idempotency_keys (user_id, key, request_hash, status, body, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := t.conn().Exec(AddIdempotentResponse,
+		t.userID,
+		resp.Key,
+		resp.RequestHash,
+		resp.Status,
+		resp.Body,
+		timestamp())
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+		return ErrKeyTaken
+	}
+	return err
+}
+
+// GetIdempotentResponse returns sql.ErrNoRows when the key has not been
+// used by the user.
+func (t TaskContainer) GetIdempotentResponse(key string) (daterules.IdempotentResponse, error) {
+	resp := daterules.IdempotentResponse{Key: key}
+	GetIdempotentResponse := `SELECT request_hash, status, body, created_at
+	FROM idempotency_keys WHERE user_id = ? AND key = ?`
+	err := t.conn().QueryRow(GetIdempotentResponse, t.userID, key).
+		Scan(&resp.RequestHash, &resp.Status, &resp.Body, &resp.CreatedAt)
+
+	return resp, err
+}
+
+// PruneIdempotency drops the responses that are no longer replayed.
+func (t TaskContainer) PruneIdempotency(before time.Time) error {
+	_, err := t.conn().Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`,
+		before.UTC().Format(time.RFC3339))
+	return err
+}
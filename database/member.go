@@ -0,0 +1,168 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+
+	"final/daterules"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// TaskAccess returns the owner of a task and the role the user has on it:
+// RoleOwner for the user's own tasks, otherwise the role of the user in
+// the project the task belongs to. Tasks the user may not see give
+// sql.ErrNoRows.
+func (t TaskContainer) TaskAccess(id string) (int64, string, error) {
+	return t.taskAccess(id, " AND s.deleted_at IS NULL")
+}
+
+// StoredTaskAccess is TaskAccess that also finds the deleted and the
+// completed tasks whose rows are still kept.
+func (t TaskContainer) StoredTaskAccess(id string) (int64, string, error) {
+	return t.taskAccess(id, "")
+}
+
+func (t TaskContainer) taskAccess(id string, where string) (int64, string, error) {
+	var owner int64
+	var role string
+	TaskAccess := `SELECT s.user_id, CASE WHEN s.user_id = ? THEN ? ELSE m.role END
+	FROM scheduler s
+	LEFT JOIN project_members m ON m.project_id = s.project_id AND m.user_id = ?
+	WHERE s.id = ?` + where + ` AND (s.user_id = ? OR m.role IS NOT NULL)`
+	err := t.conn().QueryRow(TaskAccess, t.userID, RoleOwner, t.userID, id, t.userID).Scan(&owner, &role)
+
+	return owner, role, err
+}
+
+// ProjectAccess returns the owner of a project and the role the user has
+// in it, RoleOwner for the user's own projects. Projects the user may not
+// see give sql.ErrNoRows.
+func (t TaskContainer) ProjectAccess(id string) (int64, string, error) {
+	var owner int64
+	var role string
+	ProjectAccess := `SELECT p.user_id, CASE WHEN p.user_id = ? THEN ? ELSE m.role END
+	FROM projects p
+	LEFT JOIN project_members m ON m.project_id = p.id AND m.user_id = ?
+	WHERE p.id = ? AND (p.user_id = ? OR m.role IS NOT NULL)`
+	err := t.conn().QueryRow(ProjectAccess, t.userID, RoleOwner, t.userID, id, t.userID).Scan(&owner, &role)
+
+	return owner, role, err
+}
+
+// memberProjects selects the projects shared with a user.
+const memberProjects = `SELECT project_id FROM project_members WHERE user_id = ?`
+
+// shared matches the tasks of a user and of the projects shared with the
+// user. It takes the user id twice.
+const shared = `(user_id = ? OR project_id IN (` + memberProjects + `))`
+
+// Audience is who may see a task: its owner and the members of its
+// project.
+type Audience struct {
+	Owner     int64
+	ProjectID string
+	Users     []int64
+}
+
+// TaskAudience returns the audience of a task, deleted or not. It ignores
+// the user the container is bound to.
+func (t TaskContainer) TaskAudience(id string) (Audience, error) {
+	var a Audience
+	var projectID int64
+	err := t.conn().QueryRow(`SELECT user_id, project_id FROM scheduler WHERE id = ?`, id).
+		Scan(&a.Owner, &projectID)
+	if err != nil {
+		return a, err
+	}
+	a.Users = []int64{a.Owner}
+	if projectID == 0 {
+		return a, nil
+	}
+	a.ProjectID = strconv.FormatInt(projectID, 10)
+
+	rows, err := t.conn().Query(`SELECT user_id FROM project_members WHERE project_id = ?`, projectID)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user int64
+		if err := rows.Scan(&user); err != nil {
+			return a, err
+		}
+		a.Users = append(a.Users, user)
+	}
+	return a, rows.Err()
+}
+
+// AddMember shares a project of the user with another user, or changes
+// the role of an existing member.
+func (t TaskContainer) AddMember(member daterules.Member) error {
+	AddMember := `INSERT INTO project_members (project_id, user_id, role)
+	SELECT p.id, ?, ? FROM projects p WHERE p.id = ? AND p.user_id = ?
+	ON CONFLICT (project_id, user_id) DO UPDATE SET role = excluded.role`
+	result, err := t.conn().Exec(AddMember, member.UserID, member.Role, member.ProjectID, t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong project id")
+	}
+
+	return nil
+}
+
+func (t TaskContainer) DeleteMember(projectID string, userID string) error {
+	DeleteMember := `DELETE FROM project_members WHERE project_id = ? AND user_id = ?
+	AND project_id IN (SELECT id FROM projects WHERE user_id = ?)`
+	result, err := t.conn().Exec(DeleteMember, projectID, userID, t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong member")
+	}
+
+	return nil
+}
+
+func (t TaskContainer) GetMembers(projectID string) ([]daterules.Member, error) {
+	members := []daterules.Member{}
+	GetMembers := `SELECT m.project_id, m.user_id, u.login, m.role
+	FROM project_members m
+	JOIN projects p ON p.id = m.project_id AND p.user_id = ?
+	JOIN users u ON u.id = m.user_id
+	WHERE m.project_id = ?
+	ORDER BY u.login ASC`
+	rows, err := t.conn().Query(GetMembers, t.userID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member daterules.Member
+		if err := rows.Scan(&member.ProjectID, &member.UserID, &member.Login, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+var tables = []string{
+	`CREATE TABLE IF NOT EXISTS scheduler (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		date TEXT NOT NULL,
+		title TEXT NOT NULL,
+		comment TEXT,
+		repeat TEXT NOT NULL CHECK(length(repeat) <= 128)
+	)`,
+	`CREATE TABLE IF NOT EXISTS projects (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL CHECK(length(name) <= 128)
+	)`,
+	`CREATE TABLE IF NOT EXISTS checklist (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		position INTEGER NOT NULL DEFAULT 0,
+		title TEXT NOT NULL CHECK(length(title) <= 256),
+		done INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS dependencies (
+		task_id INTEGER NOT NULL,
+		depends_on INTEGER NOT NULL,
+		PRIMARY KEY (task_id, depends_on)
+	)`,
+	`CREATE TABLE IF NOT EXISTS completions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		date TEXT NOT NULL,
+		next_date TEXT NOT NULL DEFAULT '',
+		done_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS undo_journal (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		operation TEXT NOT NULL,
+		task_id INTEGER NOT NULL,
+		before TEXT NOT NULL,
+		completion_id INTEGER NOT NULL DEFAULT 0,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS audit (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		task_id INTEGER NOT NULL,
+		before TEXT,
+		after TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		login TEXT NOT NULL UNIQUE CHECK(length(login) <= 64),
+		password_hash TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS project_members (
+		project_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		role TEXT NOT NULL CHECK(role IN ('viewer', 'editor')),
+		PRIMARY KEY (project_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL CHECK(length(name) <= 128),
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		last_used_at TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id INTEGER NOT NULL,
+		key TEXT NOT NULL CHECK(length(key) <= 255),
+		request_hash TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		PRIMARY KEY (user_id, key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		url TEXT NOT NULL CHECK(length(url) <= 2048),
+		events TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		event_id INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL CHECK(status IN ('pending', 'delivered', 'failed')),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		next_attempt_at TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		delivered_at TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS calendar_feeds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL CHECK(length(name) <= 128),
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL,
+		last_used_at TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS secrets (
+		name TEXT PRIMARY KEY,
+		value BLOB NOT NULL
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS audit_no_update BEFORE UPDATE ON audit
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS audit_no_delete BEFORE DELETE ON audit
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END`,
+}
+
+var columns = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{"scheduler", "project_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"scheduler", "deleted_at", "TEXT"},
+	{"scheduler", "version", "INTEGER NOT NULL DEFAULT 1"},
+	{"scheduler", "created_at", "TEXT"},
+	{"scheduler", "updated_at", "TEXT"},
+	{"scheduler", "user_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"scheduler", "done_at", "TEXT"},
+	{"projects", "user_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"completions", "user_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"undo_journal", "user_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"undo_journal", "version", "INTEGER NOT NULL DEFAULT 0"},
+	{"audit", "user_id", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// backfills fill the columns added to existing rows.
+var backfills = []string{
+	`UPDATE scheduler SET created_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') 
+	WHERE created_at IS NULL`,
+	`UPDATE scheduler SET updated_at = created_at WHERE updated_at IS NULL`,
+	// One-off tasks completed before done_at was added went to the trash
+	// together with their completion.
+	`UPDATE scheduler SET done_at = deleted_at
+	WHERE deleted_at IS NOT NULL AND done_at IS NULL AND EXISTS (
+		SELECT 1 FROM completions c WHERE c.task_id = scheduler.id AND c.next_date = ''
+		AND abs(strftime('%s', c.done_at) - strftime('%s', scheduler.deleted_at)) <= 2
+	)`,
+	// A repeating task never gets finished, so the tasks waiting for it
+	// stayed blocked. Repeating tasks can no longer be blockers.
+	`DELETE FROM dependencies WHERE depends_on IN (SELECT id FROM scheduler WHERE repeat != '')`,
+}
+
+var indexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_date ON scheduler (date)`,
+	`CREATE INDEX IF NOT EXISTS idx_project ON scheduler (project_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_deleted ON scheduler (deleted_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_created ON scheduler (created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_updated ON scheduler (updated_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_user ON scheduler (user_id, date)`,
+	`CREATE INDEX IF NOT EXISTS idx_projects_user ON projects (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_members_user ON project_members (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_checklist_task ON checklist (task_id, position)`,
+	`CREATE INDEX IF NOT EXISTS idx_dependencies_on ON dependencies (depends_on)`,
+	`CREATE INDEX IF NOT EXISTS idx_completions_task ON completions (task_id, done_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_completions_done ON completions (done_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_undo_created ON undo_journal (created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_task ON audit (task_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_created ON audit (created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_idempotency_created ON idempotency_keys (created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_webhooks_user ON webhooks (user_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_deliveries_due ON webhook_deliveries (status, next_attempt_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_deliveries_webhook ON webhook_deliveries (webhook_id)`,
+}
+
+// Migrate brings the schema of an existing database up to date.
+// It is safe to call on every start.
+func Migrate(db *sql.DB) error {
+	for _, query := range tables {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+	for _, query := range backfills {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	for _, query := range indexes {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addColumn(db *sql.DB, table, name, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			column, kind     string
+			value            sql.NullString
+		)
+		if err = rows.Scan(&cid, &column, &kind, &notNull, &value, &pk); err != nil {
+			return err
+		}
+		if column == name {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition))
+	return err
+}
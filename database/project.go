@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+
+	"final/daterules"
+)
+
+func (t TaskContainer) AddProject(project daterules.Project) (int64, error) {
+	AddProject := `INSERT INTO projects (name, user_id) VALUES (?, ?)`
+	result, err := t.conn().Exec(AddProject, project.Name, t.userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (t TaskContainer) EditProject(project daterules.Project) error {
+	EditProject := `UPDATE projects SET name = ? WHERE id = ? AND user_id = ?`
+	result, err := t.conn().Exec(EditProject, project.Name, project.ID, t.userID)
+	if err != nil {
+		return err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("wrong project id")
+	}
+
+	return nil
+}
+
+// DeleteProject removes the project and its memberships and moves its
+// tasks back to the default list.
+func (t TaskContainer) DeleteProject(id string) error {
+	return t.InTx(func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(`DELETE FROM projects WHERE id = ? AND user_id = ?`, id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong project id")
+		}
+		_, err = tx.conn().Exec(`DELETE FROM project_members WHERE project_id = ?`, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.conn().Exec(`UPDATE scheduler SET project_id = 0 WHERE project_id = ? AND user_id = ?`,
+			id, tx.userID)
+		return err
+	})
+}
+
+func (t TaskContainer) GetAllProjects() ([]daterules.Project, error) {
+	projects := []daterules.Project{}
+	GetAllProjects := `SELECT p.id, p.name,
+		(SELECT count(*) FROM scheduler s WHERE s.project_id = p.id AND s.deleted_at IS NULL)
+	FROM projects p WHERE p.user_id = ? OR p.id IN (` + memberProjects + `)
+	ORDER BY p.name ASC`
+	rows, err := t.conn().Query(GetAllProjects, t.userID, t.userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var project daterules.Project
+		if err := rows.Scan(&project.ID, &project.Name, &project.Count); err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
@@ -0,0 +1,15 @@
+package database
+
+// Secret returns the secret kept under name. The first call stores value
+// as the secret, so that it survives a restart.
+func (t TaskContainer) Secret(name string, value []byte) ([]byte, error) {
+	var secret []byte
+	err := t.InTx(func(tx TaskContainer) error {
+		_, err := tx.conn().Exec(`INSERT OR IGNORE INTO secrets (name, value) VALUES (?, ?)`, name, value)
+		if err != nil {
+			return err
+		}
+		return tx.conn().QueryRow(`SELECT value FROM secrets WHERE name = ?`, name).Scan(&secret)
+	})
+	return secret, err
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"final/daterules"
+)
+
+func (t TaskContainer) GetTrash() ([]daterules.Task, error) {
+	tasks := []daterules.Task{}
+	GetTrash := `SELECT ` + taskFields + ` 
+	FROM scheduler 
+	WHERE deleted_at IS NOT NULL AND done_at IS NULL AND user_id = ? 
+	ORDER BY deleted_at DESC 
+	LIMIT ?`
+	rows, err := t.conn().Query(GetTrash, t.userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (t TaskContainer) RestoreEntry(id string) error {
+	RestoreEntry := `UPDATE scheduler SET deleted_at = NULL, updated_at = ?, version = version + 1 
+	WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL AND done_at IS NULL`
+	return t.audited(AuditRestore, id, func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(RestoreEntry, timestamp(), id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong row id")
+		}
+		return nil
+	})
+}
+
+// PurgeEntry permanently removes a task from the trash together with its
+// checklist and dependencies. The completion history is kept.
+func (t TaskContainer) PurgeEntry(id string) error {
+	return t.audited(AuditPurge, id, func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(`DELETE FROM scheduler 
+		WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL AND done_at IS NULL`, id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong row id")
+		}
+		return tx.purgeOrphans()
+	})
+}
+
+// PurgeTrash permanently removes the user's tasks deleted before the given
+// time and reports how many were removed.
+func (t TaskContainer) PurgeTrash(before time.Time) (int64, error) {
+	return t.purge(`DELETE FROM scheduler WHERE deleted_at < ? AND done_at IS NULL AND user_id = ?`,
+		before.UTC().Format(time.RFC3339), t.userID)
+}
+
+// PurgeExpired is PurgeTrash for the tasks of all users.
+func (t TaskContainer) PurgeExpired(before time.Time) (int64, error) {
+	return t.purge(`DELETE FROM scheduler WHERE deleted_at < ? AND done_at IS NULL`,
+		before.UTC().Format(time.RFC3339))
+}
+
+func (t TaskContainer) purge(query string, args ...any) (int64, error) {
+	var count int64
+	err := t.InTx(func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		if count, err = result.RowsAffected(); err != nil || count == 0 {
+			return err
+		}
+		return tx.purgeOrphans()
+	})
+
+	return count, err
+}
+
+func (t TaskContainer) purgeOrphans() error {
+	_, err := t.conn().Exec(`DELETE FROM checklist 
+	WHERE task_id NOT IN (SELECT id FROM scheduler)`)
+	if err != nil {
+		return err
+	}
+	_, err = t.conn().Exec(`DELETE FROM dependencies 
+	WHERE task_id NOT IN (SELECT id FROM scheduler) 
+	OR depends_on NOT IN (SELECT id FROM scheduler)`)
+	return err
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"final/daterules"
+)
+
+const (
+	UndoEdit   = "edit"
+	UndoDone   = "done"
+	UndoDelete = "delete"
+)
+
+func (t TaskContainer) AddUndo(entry daterules.UndoEntry) (int64, error) {
+	before, err := json.Marshal(entry.Before)
+	if err != nil {
+		return 0, err
+	}
+
+	AddUndo := `INSERT INTO undo_journal (user_id, operation, task_id, before, version, completion_id, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := t.conn().Exec(AddUndo,
+		t.userID,
+		entry.Operation,
+		rowID(entry.TaskID),
+		string(before),
+		entry.Version,
+		rowID(entry.CompletionID),
+		timestamp())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// GetUndo returns the journal entry with the given id, or the latest one
+// when id is empty. Entries created before since are treated as missing.
+func (t TaskContainer) GetUndo(id string, since time.Time) (daterules.UndoEntry, error) {
+	GetUndo := `SELECT ` + undoFields + ` FROM undo_journal 
+	WHERE created_at >= ? AND (? = 0 OR id = ?) AND user_id = ? 
+	ORDER BY id DESC LIMIT 1`
+	entryID := rowID(id)
+	if id != "" && entryID == 0 {
+		return daterules.UndoEntry{}, errors.New("wrong undo id")
+	}
+
+	row := t.conn().QueryRow(GetUndo, since.UTC().Format(time.RFC3339), entryID, entryID, t.userID)
+	entry, err := scanUndo(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, errors.New("wrong undo id")
+	}
+
+	return entry, err
+}
+
+func (t TaskContainer) GetUndoList(since time.Time) ([]daterules.UndoEntry, error) {
+	entries := []daterules.UndoEntry{}
+	GetUndoList := `SELECT ` + undoFields + ` FROM undo_journal 
+	WHERE created_at >= ? AND user_id = ? 
+	ORDER BY id DESC LIMIT ?`
+	rows, err := t.conn().Query(GetUndoList, since.UTC().Format(time.RFC3339), t.userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanUndo(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (t TaskContainer) DeleteUndo(id string) error {
+	_, err := t.conn().Exec(`DELETE FROM undo_journal WHERE id = ? AND user_id = ?`, id, t.userID)
+	return err
+}
+
+// PruneUndo drops the journal entries that can no longer be undone.
+func (t TaskContainer) PruneUndo(before time.Time) error {
+	_, err := t.conn().Exec(`DELETE FROM undo_journal WHERE created_at < ?`,
+		before.UTC().Format(time.RFC3339))
+	return err
+}
+
+const undoFields = `id, operation, task_id, before, version, completion_id, created_at`
+
+func scanUndo(row scanner) (daterules.UndoEntry, error) {
+	var entry daterules.UndoEntry
+	var before string
+	err := row.Scan(&entry.ID, &entry.Operation, &entry.TaskID, &before,
+		&entry.Version, &entry.CompletionID, &entry.CreatedAt)
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal([]byte(before), &entry.Before)
+
+	return entry, err
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"errors"
+
+	"final/daterules"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var ErrLoginTaken = errors.New("login is already taken")
+
+// AddUser creates an account. Users are global, so it ignores the user
+// the container is bound to.
+func (t TaskContainer) AddUser(user daterules.User) (int64, error) {
+	AddUser := `INSERT INTO users (login, password_hash, created_at) VALUES (?, ?, ?)`
+	result, err := t.conn().Exec(AddUser, user.Login, user.PasswordHash, timestamp())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, ErrLoginTaken
+		}
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+func (t TaskContainer) GetUser(id string) (daterules.User, error) {
+	return t.getUser(`SELECT id, login, password_hash, created_at FROM users WHERE id = ?`, id)
+}
+
+func (t TaskContainer) GetUserByLogin(login string) (daterules.User, error) {
+	return t.getUser(`SELECT id, login, password_hash, created_at FROM users WHERE login = ?`, login)
+}
+
+func (t TaskContainer) getUser(query string, arg string) (daterules.User, error) {
+	var user daterules.User
+	err := t.conn().QueryRow(query, arg).Scan(&user.ID, &user.Login, &user.PasswordHash, &user.CreatedAt)
+
+	return user, err
+}
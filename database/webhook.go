@@ -0,0 +1,192 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"final/daterules"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+func (t TaskContainer) AddWebhook(hook daterules.Webhook) (int64, error) {
+	AddWebhook := `INSERT INTO webhooks (user_id, url, events, secret, created_at)
+	VALUES (?, ?, ?, ?, ?)`
+	result, err := t.conn().Exec(AddWebhook,
+		t.userID,
+		hook.URL,
+		strings.Join(hook.Events, ","),
+		hook.Secret,
+		timestamp())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// GetWebhook returns sql.ErrNoRows when the user has no such webhook. The
+// secret is left out.
+func (t TaskContainer) GetWebhook(id string) (daterules.Webhook, error) {
+	var hook daterules.Webhook
+	var events string
+	err := t.conn().QueryRow(`SELECT id, url, events, created_at FROM webhooks WHERE id = ? AND user_id = ?`,
+		id, t.userID).Scan(&hook.ID, &hook.URL, &events, &hook.CreatedAt)
+	hook.Events = strings.Split(events, ",")
+
+	return hook, err
+}
+
+func (t TaskContainer) GetWebhooks() ([]daterules.Webhook, error) {
+	hooks := []daterules.Webhook{}
+	GetWebhooks := `SELECT id, url, events, created_at
+	FROM webhooks WHERE user_id = ?
+	ORDER BY id ASC`
+	rows, err := t.conn().Query(GetWebhooks, t.userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hook daterules.Webhook
+		var events string
+		if err = rows.Scan(&hook.ID, &hook.URL, &events, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		hook.Events = strings.Split(events, ",")
+		hooks = append(hooks, hook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// DeleteWebhook removes the webhook together with its delivery log.
+func (t TaskContainer) DeleteWebhook(id string) error {
+	return t.InTx(func(tx TaskContainer) error {
+		result, err := tx.conn().Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, tx.userID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("wrong webhook id")
+		}
+
+		_, err = tx.conn().Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id)
+		return err
+	})
+}
+
+// AddDeliveries queues the event for every webhook of the users that
+// subscribed to it and returns how many deliveries were queued.
+func (t TaskContainer) AddDeliveries(users []int64, event string, eventID int64, payload string) (int64, error) {
+	if len(users) == 0 {
+		return 0, nil
+	}
+	now := timestamp()
+	args := []interface{}{event, eventID, payload, DeliveryPending, now, now}
+	for _, user := range users {
+		args = append(args, user)
+	}
+	args = append(args, event)
+
+	AddDeliveries := `INSERT INTO webhook_deliveries
+	(webhook_id, event, event_id, payload, status, next_attempt_at, created_at)
+	SELECT id, ?, ?, ?, ?, ?, ? FROM webhooks
+	WHERE user_id IN (?` + strings.Repeat(", ?", len(users)-1) + `)
+	AND ',' || events || ',' LIKE '%,' || ? || ',%'`
+	result, err := t.conn().Exec(AddDeliveries, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetDeliveries returns the latest deliveries of the webhook, newest
+// first.
+func (t TaskContainer) GetDeliveries(webhookID string, limit int) ([]daterules.Delivery, error) {
+	deliveries := []daterules.Delivery{}
+	GetDeliveries := `SELECT d.id, d.webhook_id, d.event, d.event_id, d.payload, d.status, d.attempts,
+	d.response_code, d.error, d.next_attempt_at, d.created_at, coalesce(d.delivered_at, '')
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.webhook_id = ? AND w.user_id = ?
+	ORDER BY d.id DESC LIMIT ?`
+	rows, err := t.conn().Query(GetDeliveries, webhookID, t.userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d daterules.Delivery
+		if err = rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.EventID, &d.Payload, &d.Status, &d.Attempts,
+			&d.ResponseCode, &d.Error, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		if d.Status != DeliveryPending {
+			d.NextAttemptAt = ""
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// DueDeliveries returns the pending deliveries of all users whose next
+// attempt is due at now, with the URL and secret of their webhook.
+func (t TaskContainer) DueDeliveries(now time.Time, limit int) ([]daterules.Delivery, error) {
+	deliveries := []daterules.Delivery{}
+	DueDeliveries := `SELECT d.id, d.webhook_id, d.event, d.event_id, d.payload, d.attempts, w.url, w.secret
+	FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+	WHERE d.status = ? AND d.next_attempt_at <= ?
+	ORDER BY d.next_attempt_at, d.id LIMIT ?`
+	rows, err := t.conn().Query(DueDeliveries, DeliveryPending, now.UTC().Format(time.RFC3339), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := daterules.Delivery{Status: DeliveryPending}
+		if err = rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.EventID, &d.Payload, &d.Attempts,
+			&d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// SaveDelivery records the outcome of an attempt.
+func (t TaskContainer) SaveDelivery(d daterules.Delivery) error {
+	SaveDelivery := `UPDATE webhook_deliveries SET status = ?, attempts = ?, response_code = ?,
+	error = ?, next_attempt_at = ?, delivered_at = nullif(?, '')
+	WHERE id = ?`
+	_, err := t.conn().Exec(SaveDelivery, d.Status, d.Attempts, d.ResponseCode,
+		d.Error, d.NextAttemptAt, d.DeliveredAt, d.ID)
+	return err
+}
+
+// PruneDeliveries drops the finished deliveries created before the time.
+func (t TaskContainer) PruneDeliveries(before time.Time) error {
+	_, err := t.conn().Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`,
+		DeliveryPending, before.UTC().Format(time.RFC3339))
+	return err
+}
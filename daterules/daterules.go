@@ -1,6 +1,7 @@
 package daterules
 
 import (
+	"encoding/json"
 	"errors"
 	"strconv"
 	"strings"
@@ -10,11 +11,130 @@ import (
 const TimeFormat string = "20060102"
 
 type Task struct {
-	ID      string `json:"id"`
-	Date    string `json:"date"`
-	Title   string `json:"title"`
-	Comment string `json:"comment"`
-	Repeat  string `json:"repeat"`
+	ID        string   `json:"id"`
+	Date      string   `json:"date"`
+	Title     string   `json:"title"`
+	Comment   string   `json:"comment"`
+	Repeat    string   `json:"repeat"`
+	ProjectID string   `json:"project_id,omitempty"`
+	BlockedBy []string `json:"blocked_by,omitempty"`
+	DeletedAt string   `json:"deleted_at,omitempty"`
+	Version   int      `json:"-"`
+	CreatedAt string   `json:"created_at,omitempty"`
+	UpdatedAt string   `json:"updated_at,omitempty"`
+}
+
+type User struct {
+	ID           string `json:"id"`
+	Login        string `json:"login"`
+	PasswordHash string `json:"-"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type APIToken struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+}
+
+// IdempotentResponse is the response stored for a request sent with an
+// Idempotency-Key header.
+type IdempotentResponse struct {
+	Key         string
+	RequestHash string
+	Status      int
+	Body        string
+	CreatedAt   string
+}
+
+// Webhook is a URL the events of the user are posted to. The secret signs
+// the payloads and is only shown when the webhook is added.
+type Webhook struct {
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Secret    string   `json:"secret,omitempty"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// Delivery is an attempt to post an event to a webhook.
+type Delivery struct {
+	ID            string `json:"id"`
+	WebhookID     string `json:"webhook_id"`
+	Event         string `json:"event"`
+	EventID       int64  `json:"event_id"`
+	Payload       string `json:"payload"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	ResponseCode  int    `json:"response_code,omitempty"`
+	Error         string `json:"error,omitempty"`
+	NextAttemptAt string `json:"next_attempt_at,omitempty"`
+	CreatedAt     string `json:"created_at"`
+	DeliveredAt   string `json:"delivered_at,omitempty"`
+
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// CalendarFeed is a secret URL serving the tasks of the user as an
+// iCalendar feed.
+type CalendarFeed struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+}
+
+type Member struct {
+	ProjectID string `json:"project_id"`
+	UserID    string `json:"user_id"`
+	Login     string `json:"login"`
+	Role      string `json:"role"`
+}
+
+type ChecklistItem struct {
+	ID       string `json:"id"`
+	TaskID   string `json:"task_id"`
+	Title    string `json:"title"`
+	Done     bool   `json:"done"`
+	Position int    `json:"position"`
+}
+
+type Completion struct {
+	ID       string `json:"id"`
+	TaskID   string `json:"task_id"`
+	Title    string `json:"title"`
+	Date     string `json:"date"`
+	NextDate string `json:"next_date"`
+	DoneAt   string `json:"done_at"`
+}
+
+type UndoEntry struct {
+	ID           string `json:"id"`
+	Operation    string `json:"operation"`
+	TaskID       string `json:"task_id"`
+	Before       Task   `json:"before"`
+	Version      int    `json:"-"`
+	CompletionID string `json:"-"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type AuditEntry struct {
+	ID        string          `json:"id"`
+	CreatedAt string          `json:"created_at"`
+	Actor     string          `json:"actor"`
+	Operation string          `json:"operation"`
+	TaskID    string          `json:"task_id"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+type Project struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
 }
 
 func NextTime(now time.Time, date string, repeat string) (string, error) {
@@ -27,25 +147,16 @@ func NextTime(now time.Time, date string, repeat string) (string, error) {
 		return "", err
 	}
 
-	parts := strings.Fields(repeat)
-
-	if !strings.Contains("yd", parts[0]) {
-		return "", errors.New("wrong repeat format")
-	} else if parts[0] == "d" && len(parts) != 2 {
-		return "", errors.New("wrong repeat time format")
-	} else if parts[0] == "y" && len(parts) != 1 {
-		return "", errors.New("wrong daily repeat format")
+	days, err := parseRepeat(repeat)
+	if err != nil {
+		return "", err
 	}
 
 	for {
-		if parts[0] == "y" {
+		if days == 0 {
 			startDate = startDate.AddDate(1, 0, 0)
-		} else if parts[0] == "d" {
-			part, err := strconv.Atoi(parts[1])
-			if part > 366 || part <= 0 || err != nil {
-				return "", errors.New("wrong repeat time")
-			}
-			startDate = startDate.AddDate(0, 0, part)
+		} else {
+			startDate = startDate.AddDate(0, 0, days)
 		}
 
 		if startDate.After(now) || startDate.Equal(now) {
@@ -53,3 +164,42 @@ func NextTime(now time.Time, date string, repeat string) (string, error) {
 		}
 	}
 }
+
+// CheckRepeat returns an error for a repeat rule NextTime cannot follow.
+func CheckRepeat(repeat string) error {
+	_, err := parseRepeat(repeat)
+	return err
+}
+
+// parseRepeat checks a repeat rule, "y" for every year or "d N" for every
+// N days up to 366, and returns the days between repetitions, zero for a
+// yearly task.
+func parseRepeat(repeat string) (int, error) {
+	parts := strings.Fields(repeat)
+	switch {
+	case len(parts) == 1 && parts[0] == "y":
+		return 0, nil
+	case len(parts) == 2 && parts[0] == "d":
+		days, err := strconv.Atoi(parts[1])
+		if err != nil || days <= 0 || days > 366 {
+			return 0, errors.New("wrong repeat time")
+		}
+		return days, nil
+	}
+	return 0, errors.New("wrong repeat format")
+}
+
+// RRule translates a repeat rule into the RRULE property of iCalendar
+// (RFC 5545). A yearly task moved from February 29 falls on March 1 in
+// common years, while the RRULE skips them; calendars show the leap days
+// only.
+func RRule(repeat string) (string, error) {
+	days, err := parseRepeat(repeat)
+	if err != nil {
+		return "", err
+	}
+	if days == 0 {
+		return "FREQ=YEARLY", nil
+	}
+	return "FREQ=DAILY;INTERVAL=" + strconv.Itoa(days), nil
+}
@@ -0,0 +1,37 @@
+package daterules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRRule(t *testing.T) {
+	tbl := []struct {
+		repeat string
+		rrule  string
+	}{
+		{"d 1", "FREQ=DAILY;INTERVAL=1"},
+		{"d 14", "FREQ=DAILY;INTERVAL=14"},
+		{"y", "FREQ=YEARLY"},
+		{"d 366", "FREQ=DAILY;INTERVAL=366"},
+		{"", ""},
+		{"d", ""},
+		{"d 0", ""},
+		{"d 367", ""},
+		{"d x", ""},
+		{"y 2", ""},
+		{"w 1", ""},
+	}
+
+	for _, v := range tbl {
+		got, err := RRule(v.repeat)
+		if v.rrule == "" {
+			assert.Error(t, err, v.repeat)
+			continue
+		}
+		require.NoError(t, err, v.repeat)
+		assert.Equal(t, v.rrule, got, v.repeat)
+	}
+}
@@ -0,0 +1,142 @@
+// Package events is an in-process bus that fans task changes out to the
+// connected clients and keeps the latest ones for clients that resume.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// buffer is the number of events a subscriber may fall behind before it
+// is dropped.
+const buffer = 64
+
+type Event struct {
+	ID int64
+	// Epoch is the epoch of the bus that published the event.
+	Epoch string
+	Type  string
+	Data  []byte
+	// Project is the project of the task, empty for tasks outside of
+	// projects. Users are the users allowed to see the event.
+	Project string
+	Users   []int64
+}
+
+// Cursor identifies the event for the clients that resume: the ids start
+// over with every process, so they are prefixed with its epoch.
+func (e Event) Cursor() string {
+	return e.Epoch + "-" + strconv.FormatInt(e.ID, 10)
+}
+
+func (e Event) VisibleTo(userID int64) bool {
+	return slices.Contains(e.Users, userID)
+}
+
+// Bus numbers the published events and keeps the last history of them.
+type Bus struct {
+	epoch   string
+	mu      sync.Mutex
+	last    int64
+	size    int
+	history []Event
+	subs    map[*Subscription]struct{}
+}
+
+func NewBus(history int) *Bus {
+	var epoch [4]byte
+	_, _ = rand.Read(epoch[:])
+	return &Bus{
+		epoch: hex.EncodeToString(epoch[:]),
+		size:  history,
+		subs:  make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event the next id and delivers it to the
+// subscribers. A subscriber that is too slow to keep up is dropped; it
+// can resume from the last event it saw.
+func (b *Bus) Publish(e Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.last++
+	e.ID = b.last
+	e.Epoch = b.epoch
+	if b.size > 0 {
+		if len(b.history) == b.size {
+			b.history = slices.Delete(b.history, 0, 1)
+		}
+		b.history = append(b.history, e)
+	}
+
+	for s := range b.subs {
+		select {
+		case s.ch <- e:
+		default:
+			delete(b.subs, s)
+			close(s.ch)
+		}
+	}
+	return e
+}
+
+// Subscribe starts delivering events and returns the kept events after
+// the one with the cursor, when the cursor is not empty. It reports false
+// when some of the events after it are no longer kept or the cursor is
+// unknown, for example from before a restart, so the subscriber has to
+// reload its state instead.
+func (b *Bus) Subscribe(cursor string) (*Subscription, []Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &Subscription{bus: b, ch: make(chan Event, buffer)}
+	b.subs[s] = struct{}{}
+	if cursor == "" {
+		return s, nil, true
+	}
+	epoch, id, _ := strings.Cut(cursor, "-")
+	lastID, err := strconv.ParseInt(id, 10, 64)
+	if epoch != b.epoch || err != nil || lastID > b.last {
+		return s, nil, false
+	}
+
+	first := b.last + 1
+	if len(b.history) > 0 {
+		first = b.history[0].ID
+	}
+	if lastID+1 < first {
+		return s, nil, false
+	}
+	var missed []Event
+	for _, e := range b.history {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	return s, missed, true
+}
+
+type Subscription struct {
+	bus *Bus
+	ch  chan Event
+}
+
+// Events is closed when the subscriber is dropped or closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
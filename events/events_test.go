@@ -0,0 +1,84 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ids(events []Event) []int64 {
+	var ids []int64
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestPublish(t *testing.T) {
+	b := NewBus(3)
+	s, missed, ok := b.Subscribe("")
+	assert.True(t, ok)
+	assert.Empty(t, missed)
+
+	e := b.Publish(Event{Type: "created", Users: []int64{1, 2}})
+	assert.Equal(t, int64(1), e.ID)
+	assert.Equal(t, b.epoch+"-1", e.Cursor())
+	got := <-s.Events()
+	assert.Equal(t, e.ID, got.ID)
+	assert.True(t, got.VisibleTo(2))
+	assert.False(t, got.VisibleTo(3))
+
+	s.Close()
+	s.Close()
+	_, open := <-s.Events()
+	assert.False(t, open)
+	b.Publish(Event{Type: "updated"})
+}
+
+func TestResume(t *testing.T) {
+	b := NewBus(3)
+	var published []Event
+	for i := 0; i < 5; i++ {
+		published = append(published, b.Publish(Event{Type: "updated"}))
+	}
+
+	_, missed, ok := b.Subscribe(published[1].Cursor())
+	assert.True(t, ok)
+	assert.Equal(t, []int64{3, 4, 5}, ids(missed))
+
+	_, missed, ok = b.Subscribe(published[3].Cursor())
+	assert.True(t, ok)
+	assert.Equal(t, []int64{5}, ids(missed))
+
+	_, missed, ok = b.Subscribe(published[4].Cursor())
+	assert.True(t, ok)
+	assert.Empty(t, missed)
+
+	// Event 2 has been dropped from the history.
+	_, _, ok = b.Subscribe(published[0].Cursor())
+	assert.False(t, ok)
+
+	// An id from before a restart, or past the last event.
+	_, _, ok = b.Subscribe("0badcafe-3")
+	assert.False(t, ok)
+	_, _, ok = b.Subscribe(b.epoch + "-100")
+	assert.False(t, ok)
+	_, _, ok = b.Subscribe("3")
+	assert.False(t, ok)
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	b := NewBus(0)
+	slow, _, _ := b.Subscribe("")
+	for i := 0; i < buffer+1; i++ {
+		b.Publish(Event{Type: "updated"})
+	}
+
+	var received int
+	for range slow.Events() {
+		received++
+	}
+	require.Equal(t, buffer, received)
+	slow.Close()
+}
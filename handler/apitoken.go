@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"final/auth"
+	"final/database"
+	"final/daterules"
+)
+
+// APITokens issues a personal token scripts use in the Authorization
+// header instead of signing in.
+func (t TaskService) APITokens(w http.ResponseWriter, r *http.Request) {
+	var token daterules.APIToken
+	if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	if token.Name == "" {
+		callErrorCode("Не указано название токена", http.StatusBadRequest, w)
+		return
+	}
+	if !validScopes(token.Scopes) {
+		callErrorCode("Неверные права токена", http.StatusBadRequest, w)
+		return
+	}
+
+	secret, hash, err := auth.NewAPIToken()
+	if err != nil {
+		callErrorCode("не получилось выдать токен", http.StatusInternalServerError, w)
+		return
+	}
+	id, err := t.store(r).AddAPIToken(token, hash)
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]string{
+		"id":    strconv.FormatInt(id, 10),
+		"token": secret,
+	})
+	if err != nil {
+		callErrorCode("не получилось выдать токен", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	if err := t.store(r).DeleteAPIToken(r.FormValue("id")); err != nil {
+		callErrorCode("Токен не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (t TaskService) GetAPITokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := t.store(r).GetAPITokens()
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"tokens": tokens,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func validScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, scope := range scopes {
+		if scope != database.ScopeRead && scope != database.ScopeWrite {
+			return false
+		}
+	}
+	return true
+}
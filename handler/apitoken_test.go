@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITokens(t *testing.T) {
+	service, _ := newTestService(t)
+	service.config.RequireAuth = true
+	today := time.Now().Format(TimeFormat)
+
+	issue := func(scopes string) (string, string) {
+		w := serve(service.APITokens, http.MethodPost, "/api/tokens", `{"name":"cli","scopes":`+scopes+`}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		resp := decode(t, w)
+		assert.True(t, strings.HasPrefix(resp["token"], "todo_"), resp["token"])
+		return resp["id"], resp["token"]
+	}
+	call := func(handler http.HandlerFunc, method, target, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		service.Auth(handler).ServeHTTP(w, r)
+		return w
+	}
+	tokens := func() []daterules.APIToken {
+		w := serve(service.GetAPITokens, http.MethodGet, "/api/tokens", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp struct {
+			Tokens []daterules.APIToken `json:"tokens"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Tokens
+	}
+
+	w := serve(service.APITokens, http.MethodPost, "/api/tokens", `{"name":"cli","scopes":["admin"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	readID, read := issue(`["read"]`)
+	_, write := issue(`["read","write"]`)
+	for _, token := range tokens() {
+		assert.Empty(t, token.LastUsedAt, token.ID)
+	}
+
+	add := `{"date":"` + today + `","title":"Из скрипта"}`
+	w = call(service.GetTasks, http.MethodGet, "/api/tasks", read, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = call(service.CreateTask, http.MethodPost, "/api/task", read, add)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = call(service.CreateTask, http.MethodPost, "/api/task", write, add)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	for _, token := range tokens() {
+		assert.NotEmpty(t, token.LastUsedAt, token.ID)
+		_, err := time.Parse(time.RFC3339, token.LastUsedAt)
+		assert.NoError(t, err)
+	}
+
+	w = call(service.GetTasks, http.MethodGet, "/api/tasks", "todo_unknown", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve(service.DeleteAPIToken, http.MethodDelete, "/api/tokens?id="+readID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = call(service.GetTasks, http.MethodGet, "/api/tasks", read, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Len(t, tokens(), 1)
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"final/database"
+)
+
+// store returns the storage bound to the user and author of the request.
+func (t TaskService) store(r *http.Request) database.TaskContainer {
+	return t.service.ForUser(currentUser(r).id).WithActor(actor(r))
+}
+
+func actor(r *http.Request) string {
+	if login := currentUser(r).login; login != "" {
+		return login
+	}
+	return clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (t TaskService) Audit(w http.ResponseWriter, r *http.Request) {
+	filter := database.AuditFilter{
+		TaskID:    r.FormValue("task_id"),
+		Actor:     r.FormValue("actor"),
+		Operation: r.FormValue("operation"),
+	}
+
+	var err error
+	if filter.From, err = parseMoment(r.FormValue("from")); err != nil {
+		callErrorCode("неверный формат даты", http.StatusBadRequest, w)
+		return
+	}
+	if filter.To, err = parseMoment(r.FormValue("to")); err != nil {
+		callErrorCode("неверный формат даты", http.StatusBadRequest, w)
+		return
+	}
+	filter.Limit, _ = strconv.Atoi(r.FormValue("limit"))
+	filter.Offset, _ = strconv.Atoi(r.FormValue("offset"))
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	entries, total, err := t.store(r).GetAudit(filter)
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+// parseMoment accepts either a full RFC 3339 timestamp or a date in
+// TimeFormat. An empty value gives the zero time.
+func parseMoment(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if moment, err := time.Parse(time.RFC3339, value); err == nil {
+		return moment, nil
+	}
+	return time.Parse(TimeFormat, value)
+}
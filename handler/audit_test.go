@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	db := newTestDB(t)
+	service := NewTaskService(database.NewContainer(db), Config{UndoWindow: time.Minute})
+	task := addTestTask(t, service, `{"date":"`+time.Now().Format(TimeFormat)+`","title":"Купить хлеб"}`)
+	w := serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(service.Audit, http.MethodGet, "/api/audit?task_id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Entries []daterules.AuditEntry `json:"entries"`
+		Total   int                    `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Equal(t, 2, resp.Total)
+	// Newest first: the delete keeps the task as it was, the add only
+	// what it became.
+	assert.Equal(t, database.AuditDelete, resp.Entries[0].Operation)
+	assert.Contains(t, string(resp.Entries[0].Before), "Купить хлеб")
+	assert.Equal(t, database.AuditAdd, resp.Entries[1].Operation)
+	assert.Equal(t, "null", string(resp.Entries[1].Before))
+
+	w = serve(service.Audit, http.MethodGet, "/api/audit?from=вчера", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The log is append-only even for direct access to the database.
+	_, err := db.Exec(`UPDATE audit SET actor = 'someone'`)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.Exec(`DELETE FROM audit`)
+	assert.ErrorContains(t, err, "append-only")
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM audit`).Scan(&count))
+	assert.Equal(t, 2, count)
+}
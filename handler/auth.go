@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"final/auth"
+	"final/database"
+	"final/daterules"
+)
+
+// user is the account a request is made by. The zero user owns the tasks
+// of the single-user setup.
+type user struct {
+	id       int64
+	login    string
+	readOnly bool
+}
+
+type userKey struct{}
+
+func currentUser(r *http.Request) user {
+	u, _ := r.Context().Value(userKey{}).(user)
+	return u
+}
+
+func currentUserID(r *http.Request) string {
+	return strconv.FormatInt(currentUser(r).id, 10)
+}
+
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+func (t TaskService) Register(w http.ResponseWriter, r *http.Request) {
+	if !t.config.AllowRegistration {
+		callErrorCode("Регистрация отключена", http.StatusNotFound, w)
+		return
+	}
+
+	var creds credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	if creds.Login == "" || len(creds.Login) > 64 {
+		callErrorCode("Неверный логин", http.StatusBadRequest, w)
+		return
+	}
+	if len(creds.Password) < 8 {
+		callErrorCode("Пароль должен быть не короче 8 символов", http.StatusBadRequest, w)
+		return
+	}
+
+	hash, err := auth.HashPassword(creds.Password)
+	if err != nil {
+		callErrorCode("не получилось создать пользователя", http.StatusInternalServerError, w)
+		return
+	}
+	id, err := t.service.AddUser(daterules.User{Login: creds.Login, PasswordHash: hash})
+	if errors.Is(err, database.ErrLoginTaken) {
+		callErrorCode("Логин уже занят", http.StatusConflict, w)
+		return
+	}
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	t.writeToken(w, strconv.FormatInt(id, 10), hash)
+}
+
+// dummyHash is checked against for the logins that do not exist.
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword("dummy password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+func (t TaskService) Login(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	key := "login:" + creds.Login
+	if !t.checkLockout(w, key) {
+		return
+	}
+
+	account, err := t.service.GetUserByLogin(creds.Login)
+	if err != nil {
+		// Hash the password anyway, so that the time of the answer does
+		// not tell which logins exist.
+		account.PasswordHash = dummyHash()
+	}
+	if !auth.CheckPassword(creds.Password, account.PasswordHash) || err != nil {
+		t.lockout.Fail(key)
+		callErrorCode("Неверный логин или пароль", http.StatusUnauthorized, w)
+		return
+	}
+	t.lockout.Reset(key)
+
+	t.writeToken(w, account.ID, account.PasswordHash)
+}
+
+func (t TaskService) Signin(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	if t.config.Password == "" {
+		callErrorCode("Вход по паролю не настроен", http.StatusNotFound, w)
+		return
+	}
+
+	// There is a single password, so failures are counted per client
+	// address: a stranger guessing it must not lock everybody out.
+	key := "password:" + clientIP(r)
+	if !t.checkLockout(w, key) {
+		return
+	}
+
+	given := sha256.Sum256([]byte(credentials.Password))
+	want := sha256.Sum256([]byte(t.config.Password))
+	if subtle.ConstantTimeCompare(given[:], want[:]) != 1 {
+		t.lockout.Fail(key)
+		callErrorCode("Неверный пароль", http.StatusUnauthorized, w)
+		return
+	}
+	t.lockout.Reset(key)
+
+	t.writeToken(w, "", t.config.Password)
+}
+
+// writeToken issues a token of the subject signed in with the password,
+// or with the account whose password hash it is.
+func (t TaskService) writeToken(w http.ResponseWriter, subject string, password string) {
+	now := time.Now()
+	token, err := auth.Sign(auth.Claims{
+		Subject:     subject,
+		Fingerprint: auth.Fingerprint(t.config.TokenSecret, password),
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(t.config.TokenTTL).Unix(),
+	}, t.config.TokenSecret)
+	if err != nil {
+		callErrorCode("не получилось выдать токен", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		callErrorCode("не получилось выдать токен", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+// Auth binds the request to the user of its API token or token cookie. A
+// cookie token without a subject is a shared-password token of the zero
+// user. Requests without a token are let through as the zero user unless
+// authentication is required.
+func (t TaskService) Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, found, err := t.requestUser(r)
+		if !found && t.config.RequireAuth {
+			err = auth.ErrInvalidToken
+		}
+		if err != nil {
+			callErrorCode("Требуется аутентификация", http.StatusUnauthorized, w)
+			return
+		}
+
+		if u.readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			callErrorCode("Токен не даёт права на запись", http.StatusForbidden, w)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	})
+}
+
+// requestUser returns the user of the API token or the token cookie of the
+// request; found is false when the request carries neither.
+func (t TaskService) requestUser(r *http.Request) (u user, found bool, err error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		u, err = t.bearerUser(header)
+		return u, true, err
+	}
+	if cookie, err := r.Cookie("token"); err == nil {
+		u, err = t.cookieUser(cookie.Value)
+		return u, true, err
+	}
+	return user{}, false, nil
+}
+
+func (t TaskService) bearerUser(header string) (user, error) {
+	secret, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return user{}, auth.ErrInvalidToken
+	}
+
+	token, account, err := t.service.UseAPIToken(auth.HashAPIToken(strings.TrimSpace(secret)))
+	if err != nil {
+		return user{}, err
+	}
+	id, err := strconv.ParseInt(account.ID, 10, 64)
+	if err != nil {
+		return user{}, err
+	}
+	return user{
+		id:       id,
+		login:    account.Login,
+		readOnly: !slices.Contains(token.Scopes, database.ScopeWrite),
+	}, nil
+}
+
+// cookieUser checks a token issued by writeToken. The token stops working
+// once the password it was issued for changes.
+func (t TaskService) cookieUser(value string) (user, error) {
+	var u user
+	claims, err := auth.Parse(value, t.config.TokenSecret)
+	if err != nil {
+		return u, err
+	}
+
+	password := t.config.Password
+	if claims.Subject != "" {
+		account, err := t.service.GetUser(claims.Subject)
+		if err != nil {
+			return u, err
+		}
+		u.id, err = strconv.ParseInt(account.ID, 10, 64)
+		if err != nil {
+			return u, err
+		}
+		u.login = account.Login
+		password = account.PasswordHash
+	}
+	if password == "" || !claims.CheckFingerprint(t.config.TokenSecret, password) {
+		return u, auth.ErrInvalidToken
+	}
+	return u, nil
+}
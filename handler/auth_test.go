@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"final/auth"
+	"final/database"
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// authorized calls the task list behind the Auth middleware with the token
+// cookie, if any.
+func authorized(service TaskService, token string) int {
+	r := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	if token != "" {
+		r.AddCookie(&http.Cookie{Name: "token", Value: token})
+	}
+	w := httptest.NewRecorder()
+	service.Auth(http.HandlerFunc(service.GetTasks)).ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestPasswordToken(t *testing.T) {
+	db := newTestDB(t)
+	store := database.NewContainer(db)
+	config := Config{Password: "секрет", TokenTTL: time.Hour, RequireAuth: true, TokenSecret: []byte("ключ сервера")}
+	service := NewTaskService(store, config)
+
+	assert.Equal(t, http.StatusUnauthorized, authorized(service, ""))
+
+	w := serve(service.Signin, http.MethodPost, "/api/signin", `{"password":"не тот"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(service.Signin, http.MethodPost, "/api/signin", `{"password":"секрет"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	token := decode(t, w)["token"]
+	assert.Equal(t, http.StatusOK, authorized(service, token))
+
+	// The Authorization header takes API tokens only, as documented.
+	r := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	bearer := httptest.NewRecorder()
+	service.Auth(http.HandlerFunc(service.GetTasks)).ServeHTTP(bearer, r)
+	assert.Equal(t, http.StatusUnauthorized, bearer.Code)
+
+	parts := strings.Split(token, ".")
+	assert.Equal(t, http.StatusUnauthorized, authorized(service, parts[0]+"."+parts[1]+".c2lnbmF0dXJl"))
+	assert.Equal(t, http.StatusUnauthorized, authorized(service, token+"x"))
+
+	now := time.Now().Unix()
+	fingerprint := auth.Fingerprint(config.TokenSecret, "секрет")
+	expired, err := auth.Sign(auth.Claims{Fingerprint: fingerprint, IssuedAt: now - 7200, ExpiresAt: now - 3600}, config.TokenSecret)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, authorized(service, expired))
+
+	// Knowing the password is not enough to sign a token.
+	sum := sha256.Sum256([]byte("todo-token:секрет"))
+	forged, err := auth.Sign(auth.Claims{Fingerprint: fingerprint, IssuedAt: now, ExpiresAt: now + 3600}, sum[:])
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, authorized(service, forged))
+	unsigned, err := auth.Sign(auth.Claims{IssuedAt: now, ExpiresAt: now + 3600}, config.TokenSecret)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, authorized(service, unsigned))
+
+	// Tokens outlive the service as long as the secret is kept.
+	assert.Equal(t, http.StatusOK, authorized(NewTaskService(store, config), token))
+
+	// Changing the password signs out every holder of the old one.
+	config.Password = "новый секрет"
+	assert.Equal(t, http.StatusUnauthorized, authorized(NewTaskService(store, config), token))
+
+	// Without a shared password only account tokens are accepted.
+	config.Password = ""
+	assert.Equal(t, http.StatusUnauthorized, authorized(NewTaskService(store, config), token))
+}
+
+func TestAccountToken(t *testing.T) {
+	db := newTestDB(t)
+	store := database.NewContainer(db)
+	service := NewTaskService(store, Config{TokenTTL: time.Hour, RequireAuth: true})
+	hash, err := auth.HashPassword("пароль анны")
+	require.NoError(t, err)
+	_, err = store.AddUser(daterules.User{Login: "anna", PasswordHash: hash})
+	require.NoError(t, err)
+
+	w := serve(service.Login, http.MethodPost, "/api/login", `{"login":"anna","password":"пароль анны"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	token := decode(t, w)["token"]
+	assert.Equal(t, http.StatusOK, authorized(service, token))
+
+	w = serve(service.Login, http.MethodPost, "/api/login", `{"login":"anna","password":"не тот"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	// An unknown login is answered just like a wrong password.
+	unknown := serve(service.Login, http.MethodPost, "/api/login", `{"login":"boris","password":"не тот"}`)
+	assert.Equal(t, w.Code, unknown.Code)
+	assert.Equal(t, w.Body.String(), unknown.Body.String())
+
+	hash, err = auth.HashPassword("новый пароль")
+	require.NoError(t, err)
+	_, err = db.Exec(`UPDATE users SET password_hash = ? WHERE login = 'anna'`, hash)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, authorized(service, token))
+}
+
+func TestSigninLockout(t *testing.T) {
+	service := NewTaskService(database.NewContainer(newTestDB(t)), Config{
+		Password:        "секрет",
+		TokenTTL:        time.Hour,
+		LoginAttempts:   2,
+		LockoutDuration: time.Minute,
+	})
+	signin := func(addr, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/signin", strings.NewReader(`{"password":"`+password+`"}`))
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		service.Signin(w, r)
+		return w
+	}
+
+	for range 2 {
+		assert.Equal(t, http.StatusUnauthorized, signin("203.0.113.5:4000", "угадал?").Code)
+	}
+	w := signin("203.0.113.5:4001", "секрет")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Someone guessing the password does not lock out everyone else.
+	assert.Equal(t, http.StatusOK, signin("198.51.100.7:4000", "секрет").Code)
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"final/database"
+	"final/daterules"
+)
+
+// maxBatch is the most operations a single batch may hold.
+const maxBatch = 100
+
+const (
+	BatchAtomic   = "atomic"
+	BatchContinue = "continue"
+)
+
+type batchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchOperation struct {
+	Op      string         `json:"op"`
+	ID      string         `json:"id"`
+	Task    daterules.Task `json:"task"`
+	IfMatch string         `json:"if_match"`
+	Force   bool           `json:"force"`
+}
+
+type batchResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Status  int    `json:"status"`
+	Version int    `json:"version,omitempty"`
+	UndoID  int64  `json:"undo_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// batchEvents are the events published for the applied operations.
+var batchEvents = map[string]string{
+	"create": EventCreated,
+	"update": EventUpdated,
+	"delete": EventDeleted,
+	"done":   EventDone,
+}
+
+// errBatchFailed stops an atomic batch after the failed operation.
+var errBatchFailed = errors.New("batch failed")
+
+// BatchTasks runs a list of create, update, delete and done operations in
+// one transaction. In the atomic mode, the default, the first failure
+// rolls the whole batch back and the operations that did not fail are
+// answered with 424. In the continue mode every operation is applied or
+// rolled back on its own.
+func (t TaskService) BatchTasks(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = BatchAtomic
+	}
+	if req.Mode != BatchAtomic && req.Mode != BatchContinue {
+		callErrorCode("Неизвестный режим "+req.Mode, http.StatusBadRequest, w)
+		return
+	}
+	if len(req.Operations) == 0 {
+		callErrorCode("Не указаны операции", http.StatusBadRequest, w)
+		return
+	}
+	if len(req.Operations) > maxBatch {
+		callErrorCode("Слишком много операций, не больше "+strconv.Itoa(maxBatch), http.StatusBadRequest, w)
+		return
+	}
+
+	results := make([]batchResult, len(req.Operations))
+	failed := -1
+	err := t.store(r).InTx(func(store database.TaskContainer) error {
+		for i, op := range req.Operations {
+			var res batchResult
+			err := store.InTx(func(store database.TaskContainer) error {
+				var err error
+				res, err = t.runOperation(store, op)
+				return err
+			})
+			res.Index, res.Op = i, op.Op
+			if err != nil {
+				res.Status, res.Error = errorStatus(err)
+			}
+			results[i] = res
+			if err != nil && req.Mode == BatchAtomic {
+				failed = i
+				return errBatchFailed
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+	if failed < 0 {
+		for _, res := range results {
+			if res.Status == http.StatusOK {
+				t.publish(batchEvents[res.Op], res.ID)
+			}
+		}
+	}
+
+	code := http.StatusOK
+	if failed >= 0 {
+		code = results[failed].Status
+		for i := range results {
+			if i != failed {
+				results[i] = batchResult{Index: i, Op: req.Operations[i].Op, ID: req.Operations[i].ID, Status: http.StatusFailedDependency}
+			}
+		}
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{"results": results})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) runOperation(store database.TaskContainer, op batchOperation) (batchResult, error) {
+	if op.ID == "" {
+		op.ID = op.Task.ID
+	}
+
+	var out outcome
+	var err error
+	switch op.Op {
+	case "create":
+		var id int64
+		id, err = createTask(store, op.Task)
+		out = outcome{id: strconv.FormatInt(id, 10), version: 1}
+	case "update":
+		op.Task.ID = op.ID
+		out, err = t.updateTask(store, op.Task, op.IfMatch)
+	case "delete":
+		out, err = t.deleteTask(store, op.ID)
+	case "done":
+		out, err = t.doneTask(store, op.ID, op.IfMatch, op.Force)
+	default:
+		err = fail(http.StatusBadRequest, "Неизвестная операция "+op.Op)
+	}
+	if err != nil {
+		return batchResult{ID: op.ID}, err
+	}
+	return batchResult{ID: out.id, Status: http.StatusOK, Version: out.version, UndoID: out.undoID}, nil
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"final/auth"
+	"final/daterules"
+)
+
+// icalTime is the UTC date-time format of iCalendar.
+const icalTime = "20060102T150405Z"
+
+// CalendarFeeds issues a secret URL serving the tasks of the user to
+// calendar apps, which cannot sign in. The token is only shown in this
+// answer.
+func (t TaskService) CalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	var feed daterules.CalendarFeed
+	if err := json.NewDecoder(r.Body).Decode(&feed); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+	if feed.Name == "" {
+		callErrorCode("Не указано название календаря", http.StatusBadRequest, w)
+		return
+	}
+
+	token, hash, err := auth.NewFeedToken()
+	if err != nil {
+		callErrorCode("не получилось выдать токен", http.StatusInternalServerError, w)
+		return
+	}
+	id, err := t.store(r).AddCalendarFeed(feed, hash)
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]string{
+		"id":    strconv.FormatInt(id, 10),
+		"token": token,
+		"url":   "/api/calendar.ics?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		callErrorCode("не получилось выдать токен", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) GetCalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	feeds, err := t.store(r).GetCalendarFeeds()
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"feeds": feeds,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) DeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	if err := t.store(r).DeleteCalendarFeed(r.FormValue("id")); err != nil {
+		callErrorCode("Календарь не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// Calendar serves the tasks of the feed owner as an iCalendar file: all-day
+// events by default, or to-dos with type=todo. Repeating tasks carry an
+// RRULE, so calendars show their future dates too.
+func (t TaskService) Calendar(w http.ResponseWriter, r *http.Request) {
+	kind := r.FormValue("type")
+	if kind != "" && kind != "event" && kind != "todo" {
+		callErrorCode("Неверный тип календаря", http.StatusBadRequest, w)
+		return
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		callErrorCode("Календарь не найден", http.StatusNotFound, w)
+		return
+	}
+	feed, userID, err := t.service.UseCalendarFeed(auth.HashAPIToken(token))
+	if err != nil {
+		callErrorCode("Календарь не найден", http.StatusNotFound, w)
+		return
+	}
+	tasks, err := t.service.ForUser(userID).GetCalendarEntries()
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=UTF-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tasks.ics"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	_, _ = w.Write([]byte(calendar(feed.Name, kind == "todo", tasks, time.Now())))
+}
+
+// calendar renders the tasks as an iCalendar (RFC 5545) object.
+func calendar(name string, todo bool, tasks []daterules.Task, now time.Time) string {
+	var b strings.Builder
+	line := func(l string) { foldLine(&b, l) }
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//final//scheduler//RU")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:" + escapeText(name))
+
+	component := "VEVENT"
+	if todo {
+		component = "VTODO"
+	}
+	for _, task := range tasks {
+		date, err := time.Parse(TimeFormat, task.Date)
+		if err != nil {
+			continue
+		}
+		end := date.AddDate(0, 0, 1).Format(TimeFormat)
+
+		line("BEGIN:" + component)
+		line("UID:task-" + task.ID + "@scheduler")
+		line("DTSTAMP:" + icalStamp(task.UpdatedAt, now))
+		if task.CreatedAt != "" {
+			line("CREATED:" + icalStamp(task.CreatedAt, now))
+		}
+		if task.UpdatedAt != "" {
+			line("LAST-MODIFIED:" + icalStamp(task.UpdatedAt, now))
+		}
+		line("DTSTART;VALUE=DATE:" + task.Date)
+		if todo {
+			line("DUE;VALUE=DATE:" + end)
+			line("STATUS:NEEDS-ACTION")
+		} else {
+			line("DTEND;VALUE=DATE:" + end)
+			line("TRANSP:TRANSPARENT")
+		}
+		line("SUMMARY:" + escapeText(task.Title))
+		if task.Comment != "" {
+			line("DESCRIPTION:" + escapeText(task.Comment))
+		}
+		if task.Repeat != "" {
+			if rule, err := daterules.RRule(task.Repeat); err == nil {
+				line("RRULE:" + rule)
+			}
+		}
+		line("END:" + component)
+	}
+
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+// icalStamp converts a stored RFC 3339 time, falling back to now.
+func icalStamp(stamp string, now time.Time) string {
+	parsed, err := time.Parse(time.RFC3339, stamp)
+	if err != nil {
+		parsed = now
+	}
+	return parsed.UTC().Format(icalTime)
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// foldLine writes the content line, folded into lines of at most 75
+// octets without splitting a character.
+func foldLine(b *strings.Builder, l string) {
+	limit := 75
+	for len(l) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(l[cut]) {
+			cut--
+		}
+		b.WriteString(l[:cut])
+		b.WriteString("\r\n ")
+		l = l[cut:]
+		// The leading space of a continuation counts too.
+		limit = 74
+	}
+	b.WriteString(l)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendar(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	weekly := addTestTask(t, service, `{"date":"`+today+`","title":"Полить цветы, кактус; фикус","comment":"первая\nвторая","repeat":"d 7"}`)
+	yearly := addTestTask(t, service, `{"date":"`+today+`","title":"`+strings.Repeat("День рождения ", 10)+`","repeat":"y"}`)
+	once := addTestTask(t, service, `{"date":"`+today+`","title":"Разовая"}`)
+
+	w := serve(service.CalendarFeeds, http.MethodPost, "/api/calendar/feeds", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(service.CalendarFeeds, http.MethodPost, "/api/calendar/feeds", `{"name":"Дом"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	feed := decode(t, w)
+	assert.True(t, strings.HasPrefix(feed["token"], "feed_"))
+	assert.Equal(t, "/api/calendar.ics?token="+feed["token"], feed["url"])
+
+	w = serve(service.Calendar, http.MethodGet, feed["url"], "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/calendar; charset=UTF-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+
+	for _, line := range strings.SplitAfter(body, "\r\n") {
+		assert.LessOrEqual(t, len(line), 77, line)
+	}
+	unfolded := strings.ReplaceAll(body, "\r\n ", "")
+	lines := strings.Split(strings.TrimSuffix(unfolded, "\r\n"), "\r\n")
+	assert.Equal(t, "BEGIN:VCALENDAR", lines[0])
+	assert.Equal(t, "END:VCALENDAR", lines[len(lines)-1])
+	assert.Contains(t, lines, "X-WR-CALNAME:Дом")
+	assert.Equal(t, 3, strings.Count(unfolded, "BEGIN:VEVENT"))
+	assert.NotContains(t, unfolded, "VTODO")
+
+	event := func(id string) []string {
+		start := -1
+		for i, line := range lines {
+			if line == "UID:task-"+id+"@scheduler" {
+				start = i
+			}
+			if start >= 0 && line == "END:VEVENT" {
+				return lines[start:i]
+			}
+		}
+		t.Fatalf("no event of task %s", id)
+		return nil
+	}
+	end := time.Now().AddDate(0, 0, 1).Format(TimeFormat)
+	e := event(weekly)
+	assert.Contains(t, e, "DTSTART;VALUE=DATE:"+today)
+	assert.Contains(t, e, "DTEND;VALUE=DATE:"+end)
+	assert.Contains(t, e, `SUMMARY:Полить цветы\, кактус\; фикус`)
+	assert.Contains(t, e, `DESCRIPTION:первая\nвторая`)
+	assert.Contains(t, e, "RRULE:FREQ=DAILY;INTERVAL=7")
+	e = event(yearly)
+	assert.Contains(t, e, "SUMMARY:"+strings.Repeat("День рождения ", 10))
+	assert.Contains(t, e, "RRULE:FREQ=YEARLY")
+	for _, line := range event(once) {
+		assert.False(t, strings.HasPrefix(line, "RRULE"), line)
+	}
+
+	w = serve(service.Calendar, http.MethodGet, feed["url"]+"&type=todo", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, strings.Count(w.Body.String(), "BEGIN:VTODO"))
+	assert.Contains(t, w.Body.String(), "DUE;VALUE=DATE:"+end)
+	assert.NotContains(t, w.Body.String(), "VEVENT")
+
+	w = serve(service.Calendar, http.MethodGet, feed["url"]+"&type=journal", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(service.Calendar, http.MethodGet, "/api/calendar.ics?token=feed_wrong", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(service.Calendar, http.MethodGet, "/api/calendar.ics", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(service.GetCalendarFeeds, http.MethodGet, "/api/calendar/feeds", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Feeds []daterules.CalendarFeed `json:"feeds"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Feeds, 1)
+	assert.NotEmpty(t, list.Feeds[0].LastUsedAt)
+	assert.NotContains(t, w.Body.String(), feed["token"])
+
+	w = serve(service.DeleteCalendarFeed, http.MethodDelete, "/api/calendar/feeds?id="+feed["id"], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(service.Calendar, http.MethodGet, feed["url"], "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// The feed is not paged like the task list and keeps past tasks too.
+func TestCalendarAllTasks(t *testing.T) {
+	service, store := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	for range 60 {
+		addTestTask(t, service, `{"date":"`+today+`","title":"Сегодня"}`)
+	}
+	past := time.Now().AddDate(0, -1, 0).Format(TimeFormat)
+	_, err := store.AddEntry(daterules.Task{Date: past, Title: "Просрочена"})
+	require.NoError(t, err)
+	deleted := addTestTask(t, service, `{"date":"`+today+`","title":"Удалена"}`)
+	w := serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+deleted, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(service.CalendarFeeds, http.MethodPost, "/api/calendar/feeds", `{"name":"Все"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(service.Calendar, http.MethodGet, decode(t, w)["url"], "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	body := w.Body.String()
+	assert.Equal(t, 61, strings.Count(body, "BEGIN:VEVENT"))
+	assert.Contains(t, body, "DTSTART;VALUE=DATE:"+past)
+	assert.NotContains(t, body, "Удалена")
+}
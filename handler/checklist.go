@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"final/daterules"
+)
+
+func (t TaskService) Checklist(w http.ResponseWriter, r *http.Request) {
+	var item daterules.ChecklistItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	if item.Title == "" {
+		callErrorCode("Не указан текст пункта", http.StatusBadRequest, w)
+		return
+	}
+
+	id, err := t.store(r).AddChecklistItem(item)
+	if err != nil {
+		callErrorCode("Задача не найдена", http.StatusNotFound, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]string{"id": strconv.Itoa(int(id))})
+	if err != nil {
+		callErrorCode("не получилось добавить пункт", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) GetChecklist(w http.ResponseWriter, r *http.Request) {
+	items, err := t.store(r).GetChecklist(r.FormValue("id"))
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"items": items,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) DeleteChecklistItem(w http.ResponseWriter, r *http.Request) {
+	if err := t.store(r).DeleteChecklistItem(r.FormValue("id")); err != nil {
+		callErrorCode("Пункт не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (t TaskService) ToggleChecklistItem(w http.ResponseWriter, r *http.Request) {
+	if err := t.store(r).ToggleChecklistItem(r.FormValue("id")); err != nil {
+		callErrorCode("Пункт не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (t TaskService) ReorderChecklist(w http.ResponseWriter, r *http.Request) {
+	var order struct {
+		TaskID string   `json:"task_id"`
+		IDs    []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	if err := t.store(r).ReorderChecklist(order.TaskID, order.IDs); err != nil {
+		callErrorCode("Список пунктов не совпадает с чек-листом задачи", http.StatusBadRequest, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
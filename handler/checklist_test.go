@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func checklist(t *testing.T, service TaskService, task string) []daterules.ChecklistItem {
+	w := serve(service.GetChecklist, http.MethodGet, "/api/task/checklist?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Items []daterules.ChecklistItem `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Items
+}
+
+func TestChecklistOrder(t *testing.T) {
+	service, _ := newTestService(t)
+	task := addTestTask(t, service, `{"date":"`+time.Now().Format(TimeFormat)+`","title":"Поход","repeat":"d 7"}`)
+	other := addTestTask(t, service, `{"date":"`+time.Now().Format(TimeFormat)+`","title":"Другая"}`)
+
+	var ids []string
+	for _, title := range []string{"Палатка", "Спальник", "Котелок"} {
+		w := serve(service.Checklist, http.MethodPost, "/api/task/checklist", `{"task_id":"`+task+`","title":"`+title+`"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		ids = append(ids, decode(t, w)["id"])
+	}
+	w := serve(service.Checklist, http.MethodPost, "/api/task/checklist", `{"task_id":"`+task+`","title":""}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(service.Checklist, http.MethodPost, "/api/task/checklist", `{"task_id":"999","title":"x"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(service.Checklist, http.MethodPost, "/api/task/checklist", `{"task_id":"`+other+`","title":"Чужой"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	foreign := decode(t, w)["id"]
+
+	reorder := func(ids string) int {
+		return serve(service.ReorderChecklist, http.MethodPost, "/api/task/checklist/order",
+			`{"task_id":"`+task+`","ids":`+ids+`}`).Code
+	}
+	// The order must list every item of the task exactly once.
+	for _, bad := range []string{
+		`["` + ids[0] + `","` + ids[1] + `"]`,
+		`["` + ids[0] + `","` + ids[0] + `","` + ids[1] + `"]`,
+		`["` + ids[0] + `","` + ids[1] + `","` + foreign + `"]`,
+		`["` + ids[0] + `","` + ids[1] + `","` + ids[2] + `","` + foreign + `"]`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, reorder(bad), bad)
+	}
+	assert.Equal(t, []string{"Палатка", "Спальник", "Котелок"}, titles(checklist(t, service, task)))
+
+	require.Equal(t, http.StatusOK, reorder(`["`+ids[2]+`","`+ids[0]+`","`+ids[1]+`"]`))
+	items := checklist(t, service, task)
+	assert.Equal(t, []string{"Котелок", "Палатка", "Спальник"}, titles(items))
+
+	// Completing a repeating task unchecks its items.
+	w = serve(service.ToggleChecklistItem, http.MethodPost, "/api/task/checklist/toggle?id="+ids[0], "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, checklist(t, service, task)[1].Done)
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	for _, item := range checklist(t, service, task) {
+		assert.False(t, item.Done, item.Title)
+	}
+
+	w = serve(service.DeleteChecklistItem, http.MethodDelete, "/api/task/checklist?id="+ids[1], "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(service.DeleteChecklistItem, http.MethodDelete, "/api/task/checklist?id="+ids[1], "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, []string{"Котелок", "Палатка"}, titles(checklist(t, service, task)))
+}
+
+func titles(items []daterules.ChecklistItem) []string {
+	var titles []string
+	for _, item := range items {
+		titles = append(titles, item.Title)
+	}
+	return titles
+}
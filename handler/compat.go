@@ -0,0 +1,29 @@
+package handler
+
+import "net/http"
+
+// CompatErrors answers the failures with status 200 and the error
+// envelope, as the web client expects. Authentication, permission and
+// precondition errors keep their status.
+func CompatErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(compatWriter{w}, r)
+	})
+}
+
+type compatWriter struct {
+	http.ResponseWriter
+}
+
+func (w compatWriter) WriteHeader(code int) {
+	switch code {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed,
+		http.StatusConflict, http.StatusInternalServerError:
+		code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w compatWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
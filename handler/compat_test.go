@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tbl := []struct {
+		target string
+		code   int
+		compat int
+	}{
+		{"/api/nextdate?now=20240126&date=20240125&repeat=d+7", http.StatusOK, http.StatusOK},
+		{"/api/nextdate?now=bad&date=20240125&repeat=d+7", http.StatusBadRequest, http.StatusOK},
+		{"/api/nextdate?now=20240126&date=20240125&repeat=d+401", http.StatusBadRequest, http.StatusOK},
+	}
+
+	for _, v := range tbl {
+		w := httptest.NewRecorder()
+		NextDeadLine(w, httptest.NewRequest(http.MethodGet, v.target, nil))
+		assert.Equal(t, v.code, w.Code, v.target)
+
+		w = httptest.NewRecorder()
+		CompatErrors(http.HandlerFunc(NextDeadLine)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, v.target, nil))
+		assert.Equal(t, v.compat, w.Code, v.target)
+
+		if v.code != http.StatusOK {
+			var envelope map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope), v.target)
+			assert.NotEmpty(t, envelope["error"], v.target)
+		}
+	}
+}
+
+func TestCompatKeepsAuthErrors(t *testing.T) {
+	for _, code := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		CompatErrors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			callErrorCode("error", code, w)
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/tasks", nil))
+		assert.Equal(t, code, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	MethodNotAllowed(w, httptest.NewRequest(http.MethodPatch, "/api/trash", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
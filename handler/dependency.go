@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"final/database"
+)
+
+func (t TaskService) Dependencies(w http.ResponseWriter, r *http.Request) {
+	var dependency struct {
+		ID        string `json:"id"`
+		DependsOn string `json:"depends_on"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&dependency); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	err := t.store(r).AddDependency(dependency.ID, dependency.DependsOn)
+	switch {
+	case errors.Is(err, database.ErrSelfDependency):
+		callErrorCode("Задача не может зависеть от самой себя", http.StatusBadRequest, w)
+		return
+	case errors.Is(err, database.ErrDependencyLoop):
+		callErrorCode("Зависимость образует цикл", http.StatusConflict, w)
+		return
+	case errors.Is(err, database.ErrRepeatingBlocker):
+		callErrorCode("Повторяющаяся задача не может блокировать другие задачи", http.StatusBadRequest, w)
+		return
+	case err != nil:
+		callErrorCode("Задача не найдена", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (t TaskService) DeleteDependency(w http.ResponseWriter, r *http.Request) {
+	if err := t.store(r).DeleteDependency(r.FormValue("id"), r.FormValue("depends_on")); err != nil {
+		callErrorCode("Зависимость не найдена", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
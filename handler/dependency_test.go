@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDependencies(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	a := addTestTask(t, service, `{"date":"`+today+`","title":"Купить краску"}`)
+	b := addTestTask(t, service, `{"date":"`+today+`","title":"Покрасить забор"}`)
+	c := addTestTask(t, service, `{"date":"`+today+`","title":"Позвать гостей"}`)
+
+	depend := func(id, on string) int {
+		return serve(service.Dependencies, http.MethodPost, "/api/task/depends",
+			`{"id":"`+id+`","depends_on":"`+on+`"}`).Code
+	}
+	require.Equal(t, http.StatusOK, depend(b, a))
+	require.Equal(t, http.StatusOK, depend(c, b))
+
+	assert.Equal(t, http.StatusBadRequest, depend(a, a))
+	// a <- b <- c, so a waiting for c or b closes a cycle.
+	assert.Equal(t, http.StatusConflict, depend(a, c))
+	assert.Equal(t, http.StatusConflict, depend(a, b))
+	assert.Equal(t, http.StatusNotFound, depend(a, "999"))
+
+	w := serve(service.ActionableTasks, http.MethodGet, "/api/tasks/actionable", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Tasks []daterules.Task `json:"tasks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Tasks, 1)
+	assert.Equal(t, a, list.Tasks[0].ID)
+
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+b, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), a)
+
+	// Finishing the blocker unblocks the task.
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+a, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+b, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(service.DeleteDependency, http.MethodDelete, "/api/task/depends?id="+c+"&depends_on="+b, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(service.DeleteDependency, http.MethodDelete, "/api/task/depends?id="+c+"&depends_on="+b, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// A repeating task moves to its next date instead of being finished, so it
+// cannot block other tasks.
+func TestRepeatingBlocker(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	weekly := addTestTask(t, service, `{"date":"`+today+`","title":"Отчёт","repeat":"d 7"}`)
+	blocker := addTestTask(t, service, `{"date":"`+today+`","title":"Собрать данные"}`)
+	task := addTestTask(t, service, `{"date":"`+today+`","title":"Отправить отчёт"}`)
+
+	w := serve(service.Dependencies, http.MethodPost, "/api/task/depends", `{"id":"`+task+`","depends_on":"`+weekly+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(service.Dependencies, http.MethodPost, "/api/task/depends", `{"id":"`+task+`","depends_on":"`+blocker+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(service.PatchTask, http.MethodPatch, "/api/task?id="+blocker, `{"repeat":"d 1"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(service.UpdateTask, http.MethodPut, "/api/task", `{"id":"`+blocker+`","date":"`+today+`","title":"Собрать данные","repeat":"y"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Undo cannot bring the rule back once the task blocks others.
+	w = serve(service.PatchTask, http.MethodPatch, "/api/task?id="+weekly, `{"repeat":null}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(service.Dependencies, http.MethodPost, "/api/task/depends", `{"id":"`+task+`","depends_on":"`+weekly+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(service.Undo, http.MethodPost, "/api/undo", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	got, _ := getTestTask(t, service, weekly)
+	assert.Equal(t, "", got.Repeat)
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// matchVersion compares the If-Match header sent as match with the
+// current version of a task. It returns the version the change must still
+// find when it is written, or 0 when the client asked for no particular
+// version and the last write wins.
+func (t TaskService) matchVersion(match string, version int) (int, error) {
+	if match == "" {
+		if t.config.RequireIfMatch {
+			return 0, fail(http.StatusPreconditionRequired, "Не указан заголовок If-Match")
+		}
+		return 0, nil
+	}
+
+	for _, tag := range strings.Split(match, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return 0, nil
+		}
+		if strings.TrimPrefix(tag, "W/") == etag(version) {
+			return version, nil
+		}
+	}
+	return 0, fail(http.StatusPreconditionFailed, "Задача была изменена другим пользователем")
+}
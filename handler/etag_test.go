@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfMatch(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	id := addTestTask(t, service, `{"date":"`+today+`","title":"Помыть окна","repeat":"d 7"}`)
+
+	do := func(handler http.HandlerFunc, method, target, ifMatch, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		handler(w, r)
+		return w
+	}
+	edit := func(ifMatch, title string) *httptest.ResponseRecorder {
+		return do(service.UpdateTask, http.MethodPut, "/api/task", ifMatch,
+			`{"id":"`+id+`","date":"`+today+`","title":"`+title+`","repeat":"d 7"}`)
+	}
+
+	w := edit(`"1"`, "Помыть окна и балкон")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	// A client still holding version 1 must not overwrite version 2.
+	for _, stale := range []string{`"1"`, `W/"1"`, `"1", "3"`} {
+		w = edit(stale, "Помыть окна")
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, stale)
+	}
+	w = do(service.PatchTask, http.MethodPatch, "/api/task?id="+id, `"1"`, `{"title":"Помыть окна"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = do(service.DoneTask, http.MethodPost, "/api/task/done?id="+id, `"1"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(service.GetTaskByID, http.MethodGet, "/api/task?id="+id, "")
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	got, _ := getTestTask(t, service, id)
+	assert.Equal(t, "Помыть окна и балкон", got.Title)
+	assert.Equal(t, today, got.Date)
+
+	assert.Equal(t, http.StatusOK, edit(`W/"2"`, "Помыть окна").Code)
+	assert.Equal(t, http.StatusOK, edit(`*`, "Помыть окна").Code)
+
+	// The header is optional unless the server demands it.
+	assert.Equal(t, http.StatusOK, edit("", "Помыть окна").Code)
+	service.config.RequireIfMatch = true
+	assert.Equal(t, http.StatusPreconditionRequired, edit("", "Помыть окна").Code)
+	assert.Equal(t, http.StatusOK, edit(`"5"`, "Помыть окна").Code)
+}
+
+// Without If-Match the last write wins, even when the task changes between
+// reading and writing it.
+func TestConcurrentEdits(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	id := addTestTask(t, service, `{"date":"`+today+`","title":"Помыть окна"}`)
+
+	codes := make([]int, 30)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serve(service.UpdateTask, http.MethodPut, "/api/task",
+				`{"id":"`+id+`","date":"`+today+`","title":"Помыть окна `+strconv.Itoa(i)+`"}`)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+	for _, code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	w := serve(service.GetTaskByID, http.MethodGet, "/api/task?id="+id, "")
+	assert.Equal(t, `"31"`, w.Header().Get("ETag"))
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"final/daterules"
+	"final/events"
+)
+
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+	EventDone    = "done"
+)
+
+// defaultHeartbeat is used when Config.Heartbeat is not set.
+const defaultHeartbeat = 15 * time.Second
+
+// taskEvent is the data of an event. Task is left out when the task is
+// gone.
+type taskEvent struct {
+	ID   string          `json:"id"`
+	Task *daterules.Task `json:"task,omitempty"`
+}
+
+// publish announces a change of the task to its owner and the members of
+// its project, and queues it for their webhooks. It is called once the
+// change is committed.
+func (t TaskService) publish(kind string, id string) {
+	audience, err := t.service.TaskAudience(id)
+	if err != nil {
+		log.Println("event of task", id, "not published:", err)
+		return
+	}
+
+	data := taskEvent{ID: id}
+	if task, err := t.service.ForUser(audience.Owner).GetEntry(id); err == nil {
+		data.Task = &task
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		log.Println("event of task", id, "not published:", err)
+		return
+	}
+	e := t.bus.Publish(events.Event{
+		Type:    kind,
+		Data:    body,
+		Project: audience.ProjectID,
+		Users:   audience.Users,
+	})
+	if err := t.hooks.Enqueue(e); err != nil {
+		log.Println("webhooks of event", e.ID, "not queued:", err)
+	}
+}
+
+// Events streams the changes of the tasks the user can see as Server-Sent
+// Events. A client that reconnects with the Last-Event-ID header gets the
+// events it missed, or a reset event when they are no longer kept or the
+// server has restarted since, and it has to reload the tasks.
+func (t TaskService) Events(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r).id
+	sub, missed, complete := t.bus.Subscribe(r.Header.Get("Last-Event-ID"))
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = fmt.Fprint(w, "retry: 3000\n\n")
+	if !complete {
+		_, _ = fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		writeEvent(w, userID, e)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := t.config.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			_, _ = fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind; the client resumes from
+				// the last event it got.
+				return
+			}
+			writeEvent(w, userID, e)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, userID int64, e events.Event) {
+	if !e.VisibleTo(userID) {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.Cursor(), e.Type, e.Data)
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"final/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sseMessage struct {
+	id, event, data, comment string
+}
+
+// readEvents connects to the stream and sends its messages to the
+// returned channel until the test ends.
+func readEvents(t *testing.T, url string, lastID string) <-chan sseMessage {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	messages := make(chan sseMessage, 16)
+	go func() {
+		defer close(messages)
+		var msg sseMessage
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				messages <- msg
+				msg = sseMessage{}
+				continue
+			}
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "":
+				msg.comment = value
+			case "id":
+				msg.id = value
+			case "event":
+				msg.event = value
+			case "data":
+				msg.data = value
+			}
+		}
+	}()
+	return messages
+}
+
+// nextEvent skips the retry and heartbeat messages.
+func nextEvent(t *testing.T, messages <-chan sseMessage) sseMessage {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-messages:
+			require.True(t, ok, "stream closed")
+			if msg.event != "" {
+				return msg
+			}
+		case <-timeout:
+			t.Fatal("no event")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	service, _ := newTestService(t)
+	service.bus = events.NewBus(10)
+	service.config.Heartbeat = 10 * time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(service.Events))
+	t.Cleanup(server.Close)
+	today := time.Now().Format(TimeFormat)
+
+	messages := readEvents(t, server.URL, "")
+
+	id := addTestTask(t, service, `{"date":"`+today+`","title":"Забрать посылку"}`)
+	msg := nextEvent(t, messages)
+	assert.Equal(t, EventCreated, msg.event)
+	epoch, seq, _ := strings.Cut(msg.id, "-")
+	assert.NotEmpty(t, epoch)
+	assert.Equal(t, "1", seq)
+	var data struct {
+		ID   string `json:"id"`
+		Task *struct {
+			Title string `json:"title"`
+		} `json:"task"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(msg.data), &data))
+	assert.Equal(t, id, data.ID)
+	require.NotNil(t, data.Task)
+	assert.Equal(t, "Забрать посылку", data.Task.Title)
+
+	// The events of other users are not sent.
+	service.bus.Publish(events.Event{Type: EventUpdated, Data: []byte("{}"), Users: []int64{42}})
+
+	w := serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+id, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	msg = nextEvent(t, messages)
+	assert.Equal(t, EventDone, msg.event)
+	assert.Equal(t, epoch+"-3", msg.id)
+	assert.JSONEq(t, `{"id":"`+id+`"}`, msg.data)
+
+	var heartbeat bool
+	for !heartbeat {
+		heartbeat = (<-messages).comment == "heartbeat"
+	}
+
+	resumed := readEvents(t, server.URL, epoch+"-1")
+	msg = nextEvent(t, resumed)
+	assert.Equal(t, EventDone, msg.event)
+	assert.Equal(t, epoch+"-3", msg.id)
+
+	reset := readEvents(t, server.URL, epoch+"-100")
+	assert.Equal(t, "reset", nextEvent(t, reset).event)
+
+	// The id of an event sent before a restart.
+	restarted := readEvents(t, server.URL, "0badcafe-1")
+	assert.Equal(t, "reset", nextEvent(t, restarted).event)
+}
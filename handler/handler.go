@@ -3,14 +3,21 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"strings"
 	"time"
 
+	"final/auth"
 	"final/database"
 	"final/daterules"
+	"final/events"
+	"final/ratelimit"
+	"final/webhook"
 
+	"github.com/go-chi/chi/v5"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -18,102 +25,227 @@ var (
 	TimeFormat string = daterules.TimeFormat
 )
 
+type Config struct {
+	UndoWindow time.Duration
+	// RequireIfMatch makes writes to a task without an If-Match header
+	// fail with 428. It is off by default because the bundled web client
+	// does not send the header, and then the last write wins; a stale
+	// If-Match is rejected with 412 either way.
+	RequireIfMatch bool
+	Password       string
+	TokenTTL       time.Duration
+	// TokenSecret signs the tokens the server issues. Without it a random
+	// one is used, and the tokens stop working after a restart.
+	TokenSecret []byte
+	RequireAuth bool
+	// AllowRegistration opens /api/register to anyone. It is off by
+	// default, so that a server protected by a password does not hand out
+	// accounts to strangers.
+	AllowRegistration bool
+
+	// IPRate and AccountRate are the requests per second allowed to a
+	// client address and to a signed-in user, with bursts of up to
+	// IPBurst and AccountBurst requests. A zero rate turns a limit off.
+	IPRate       float64
+	IPBurst      int
+	AccountRate  float64
+	AccountBurst int
+	// LoginAttempts failed sign-ins in a row lock the account for
+	// LockoutDuration.
+	LoginAttempts   int
+	LockoutDuration time.Duration
+	// IdempotencyTTL is how long the responses to requests sent with an
+	// Idempotency-Key header are replayed.
+	IdempotencyTTL time.Duration
+	// Heartbeat is the interval of the comments that keep idle event
+	// streams open. EventHistory events are kept for clients that resume.
+	Heartbeat    time.Duration
+	EventHistory int
+	// WebhookAttempts deliveries of an event are tried, the first retry
+	// after WebhookBackoff and each next one after twice the delay.
+	WebhookAttempts int
+	WebhookBackoff  time.Duration
+	// WebhookAllow are the internal networks webhooks may still post to.
+	WebhookAllow []netip.Prefix
+}
+
 type TaskService struct {
-	service database.TaskContainer
+	service   database.TaskContainer
+	config    Config
+	ipLimit   *ratelimit.Limiter
+	userLimit *ratelimit.Limiter
+	lockout   *ratelimit.Lockout
+	bus       *events.Bus
+	hooks     *webhook.Worker
 }
 
-func NewTaskService(store database.TaskContainer) TaskService {
-	return TaskService{service: store}
+func NewTaskService(store database.TaskContainer, config Config) TaskService {
+	if len(config.TokenSecret) == 0 {
+		secret, err := auth.NewKey()
+		if err != nil {
+			panic(err)
+		}
+		config.TokenSecret = secret
+	}
+	return TaskService{
+		service:   store,
+		config:    config,
+		ipLimit:   ratelimit.NewLimiter(config.IPRate, config.IPBurst),
+		userLimit: ratelimit.NewLimiter(config.AccountRate, config.AccountBurst),
+		lockout:   ratelimit.NewLockout(config.LoginAttempts, config.LockoutDuration),
+		bus:       events.NewBus(config.EventHistory),
+		hooks: webhook.NewWorker(store, webhook.Config{
+			Attempts: config.WebhookAttempts,
+			Backoff:  config.WebhookBackoff,
+			Allow:    config.WebhookAllow,
+		}),
+	}
 }
 
-func (t TaskService) Task(w http.ResponseWriter, r *http.Request) {
+// CreateTask adds a task. With an Idempotency-Key header a repeated
+// request is answered with the response to the first one.
+func (t TaskService) CreateTask(w http.ResponseWriter, r *http.Request) {
 	var task daterules.Task
 	var buf bytes.Buffer
-	var date time.Time
 
-	if r.Method == http.MethodGet {
-		t.service.GetAllEntries()
+	_, err := buf.ReadFrom(r.Body)
+	if err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
 		return
-	} else if r.Method == http.MethodDelete {
-		t.DeleteTask(w, r)
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &task); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
 		return
 	}
 
-	now, _ := time.Parse(TimeFormat, time.Now().Format(TimeFormat))
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		resp, replay, err := t.createIdempotent(t.store(r), key, buf.Bytes(), task)
+		if err != nil {
+			writeOpError(w, err)
+			return
+		}
+		if replay {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(resp.Status)
+		_, _ = w.Write([]byte(resp.Body))
+		return
+	}
 
-	_, err := buf.ReadFrom(r.Body)
+	id, err := createTask(t.store(r), task)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeOpError(w, err)
 		return
 	}
+	t.publish(EventCreated, strconv.FormatInt(id, 10))
 
-	if err = json.Unmarshal(buf.Bytes(), &task); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	resp, err := json.Marshal(map[string]string{"id": strconv.Itoa(int(id))})
+	if err != nil {
+		callErrorCode("не получилось создать напоминание", http.StatusInternalServerError, w)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp)
+}
 
-	if task.Title == "" {
-		callError("Не указан заголовок задачи", w)
+// UpdateTask replaces the editable fields of a task sent in full.
+func (t TaskService) UpdateTask(w http.ResponseWriter, r *http.Request) {
+	var task daterules.Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
 		return
 	}
 
+	out, err := t.updateTask(t.store(r), task, r.Header.Get("If-Match"))
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	t.publish(EventUpdated, out.id)
+	writeOutcome(w, out)
+}
+
+// checkDate sets an empty date to today and moves a past date to today or
+// to the next repetition of the task. It returns the error message for an
+// invalid date or repeat rule.
+func checkDate(task *daterules.Task) string {
+	var date time.Time
+	var err error
+
+	now, _ := time.Parse(TimeFormat, time.Now().Format(TimeFormat))
+
 	if task.Date == "" {
 		task.Date = time.Now().Format(TimeFormat)
 		date, _ = time.Parse(TimeFormat, time.Now().Format(TimeFormat))
 	} else {
 		date, err = time.Parse(TimeFormat, task.Date)
 		if err != nil {
-			callError("неверный формат даты", w)
-			return
+			return "неверный формат даты"
 		}
 	}
 
+	// A future date is kept as is, but its repeat rule must still work
+	// once the task is done.
+	if task.Repeat != "" && daterules.CheckRepeat(task.Repeat) != nil {
+		return "неверный формат"
+	}
+
 	if now.After(date) {
 		if task.Repeat == "" {
 			task.Date = time.Now().Format(TimeFormat)
 		} else {
 			task.Date, err = daterules.NextTime(time.Now(), task.Date, task.Repeat)
 			if err != nil {
-				callError("неверный формат", w)
-				return
+				return "неверный формат"
 			}
 		}
 	}
-	if r.Method == http.MethodPut {
-		t.EditTask(w, r, task)
-		return
-	}
-
-	id, err := t.service.AddEntry(task)
-	if err != nil {
-		callError("Ошибка базы данных", w)
-		return
-	}
+	return ""
+}
 
-	resp, err := json.Marshal(map[string]string{"id": strconv.Itoa(int(id))})
-	if err != nil {
-		callError("не получилось создать напоминание", w)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(resp)
+func (t TaskService) GetTasks(w http.ResponseWriter, r *http.Request) {
+	t.writeTasks(w, t.store(r), database.Filter{
+		ProjectID: r.FormValue("project_id"),
+		Sort:      r.FormValue("sort"),
+		Desc:      r.FormValue("order") == "desc",
+	})
+}
 
+func (t TaskService) ActionableTasks(w http.ResponseWriter, r *http.Request) {
+	t.writeTasks(w, t.store(r), database.Filter{
+		ProjectID:  r.FormValue("project_id"),
+		Actionable: true,
+		Sort:       r.FormValue("sort"),
+		Desc:       r.FormValue("order") == "desc",
+	})
 }
 
-func (t TaskService) GetTasks(w http.ResponseWriter, r *http.Request) {
+func (t TaskService) writeTasks(w http.ResponseWriter, store database.TaskContainer, filter database.Filter) {
 	tasks := []daterules.Task{}
 
-	count, err := t.service.CountEntries()
+	err := filter.Valid()
+	switch {
+	case errors.Is(err, database.ErrWrongProject):
+		callErrorCode("неверный идентификатор проекта", http.StatusBadRequest, w)
+		return
+	case err != nil:
+		callErrorCode("неверный параметр сортировки", http.StatusBadRequest, w)
+		return
+	}
+
+	count, err := store.CountEntries(filter)
 	if err != nil {
-		callError("Ошибка базы данных", w)
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
 		return
 	}
 
 	if count > 0 {
-		tasks, err = t.service.GetAllEntries()
+		tasks, err = store.GetAllEntries(filter)
 		if err != nil {
-			callError("Оошибка базы данных", w)
+			callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
 			return
 		}
 	}
@@ -121,7 +253,8 @@ func (t TaskService) GetTasks(w http.ResponseWriter, r *http.Request) {
 		"tasks": tasks,
 	})
 	if err != nil {
-		callError("Ошибка десериализации JSON", w)
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
@@ -132,7 +265,7 @@ func (t TaskService) GetTasks(w http.ResponseWriter, r *http.Request) {
 func NextDeadLine(w http.ResponseWriter, r *http.Request) {
 	now, err := time.Parse(TimeFormat, r.URL.Query().Get("now"))
 	if err != nil {
-		http.NotFound(w, r)
+		callErrorCode("неверный формат даты", http.StatusBadRequest, w)
 		return
 	}
 	date := r.URL.Query().Get("date")
@@ -140,7 +273,7 @@ func NextDeadLine(w http.ResponseWriter, r *http.Request) {
 
 	deadline, err := daterules.NextTime(now, date, repeat)
 	if err != nil {
-		fmt.Fprint(w, err)
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
 		return
 	}
 
@@ -149,126 +282,71 @@ func NextDeadLine(w http.ResponseWriter, r *http.Request) {
 }
 
 func (t TaskService) GetTaskByID(w http.ResponseWriter, r *http.Request) {
-	var task daterules.Task
-
-	id := r.FormValue("id")
-	row, err := t.service.GetEntry(id)
+	task, err := getTask(t.store(r), r.FormValue("id"))
 	if err != nil {
-		callError("Ошибка базы данных", w)
+		writeOpError(w, err)
 		return
 	}
 
-	err = row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
+	resp, err := json.Marshal(task)
 	if err != nil {
-		callError("Задача не найдена", w)
+		callErrorCode("ошибка десериализации JSON", http.StatusInternalServerError, w)
 		return
 	}
-
-	resp, err := json.Marshal(map[string]string{
-		"id":      task.ID,
-		"date":    task.Date,
-		"title":   task.Title,
-		"comment": task.Comment,
-		"repeat":  task.Repeat,
-	})
-	if err != nil {
-		callError("ошибка десериализации JSON", w)
-	}
+	w.Header().Set("ETag", etag(task.Version))
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
-func (t TaskService) EditTask(w http.ResponseWriter, h *http.Request, task daterules.Task) {
-	var checkerrortask daterules.Task
-	row, _ := t.service.GetEntry(task.ID)
-	err := row.Scan(&checkerrortask.ID, &checkerrortask.Date, &checkerrortask.Title, &checkerrortask.Comment, &checkerrortask.Repeat)
-	if err != nil {
-		callError("задача не найдена", w)
-		return
-
-	}
-	err = t.service.EditEntry(task)
-	if err != nil {
-		callError("ошибка подключения к базе данных", w)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	_, _ = w.Write([]byte("{}"))
-
-}
-
 func (t TaskService) DoneTask(w http.ResponseWriter, r *http.Request) {
-	var task daterules.Task
-
-	now, _ := time.Parse(TimeFormat, time.Now().Format(TimeFormat))
-
-	id := r.FormValue("id")
-	row, err := t.service.GetEntry(id)
-	if err != nil {
-		callError("Ошибка базы данных", w)
-		return
-	}
-
-	err = row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
-	if err != nil {
-		callError("Задача не найдена", w)
-		return
-	}
-
-	if task.Repeat == "" {
-		err = t.service.DeleteEntry(task.ID)
-		if err != nil {
-			callError("не получилоось отметить задачу выполненной", w)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.Write([]byte("{}"))
-		return
-	} else {
-		task.Date, err = daterules.NextTime(now, task.Date, task.Repeat)
-	}
+	out, err := t.doneTask(t.store(r), r.FormValue("id"), r.Header.Get("If-Match"), r.FormValue("force") == "true")
 	if err != nil {
-		callError("не получилось найти следующую дату", w)
+		writeOpError(w, err)
 		return
 	}
-	err = t.service.EditEntry(task)
-	if err != nil {
-		callError("не получилось обновить дату в задаче", w)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	_, _ = w.Write([]byte("{}"))
+	t.publish(EventDone, out.id)
+	writeOutcome(w, out)
 }
 
 func (t TaskService) DeleteTask(w http.ResponseWriter, r *http.Request) {
-	var task daterules.Task
-
-	id := r.FormValue("id")
-	row, err := t.service.GetEntry(id)
+	out, err := t.deleteTask(t.store(r), r.FormValue("id"))
 	if err != nil {
-		callError("Ошибка базы данных", w)
+		writeOpError(w, err)
 		return
 	}
-
-	err = row.Scan(&task.ID, &task.Date, &task.Title, &task.Comment, &task.Repeat)
-	if err != nil {
-		callError("Задача не найдена", w)
-		return
-	}
-
-	err = t.service.DeleteEntry(task.ID)
-	if err != nil {
-		callError("не получилось удалить задачу", w)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	_, _ = w.Write([]byte("{}"))
+	t.publish(EventDeleted, out.id)
+	writeOutcome(w, out)
 }
 
-func callError(txt string, w http.ResponseWriter) {
+// callErrorCode writes the error envelope every handler answers failures
+// with.
+func callErrorCode(txt string, code int, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]string{"error": txt})
 }
+
+// NotFound answers a request to an unknown API path.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	callErrorCode("Адрес "+r.URL.Path+" не найден", http.StatusNotFound, w)
+}
+
+// MethodNotAllowed answers a request to a known path with a method the
+// router has no route for, listing the methods it has in the Allow header
+// as RFC 9110 requires.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.Routes != nil {
+		var allowed []string
+		for _, method := range []string{
+			http.MethodGet, http.MethodHead, http.MethodPost,
+			http.MethodPut, http.MethodPatch, http.MethodDelete,
+		} {
+			if rctx.Routes.Match(chi.NewRouteContext(), method, r.URL.Path) {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+	}
+	callErrorCode("Метод "+r.Method+" не поддерживается", http.StatusMethodNotAllowed, w)
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/go-chi/chi/v5"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := database.Open(filepath.Join(t.TempDir(), "scheduler.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(db))
+	return db
+}
+
+func newTestService(t *testing.T) (TaskService, database.TaskContainer) {
+	store := database.NewContainer(newTestDB(t))
+	return NewTaskService(store, Config{UndoWindow: time.Minute}), store
+}
+
+func serve(handler http.HandlerFunc, method string, target string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	var m map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m), w.Body.String())
+	return m
+}
+
+func addTestTask(t *testing.T, service TaskService, body string) string {
+	w := serve(service.CreateTask, http.MethodPost, "/api/task", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	id := decode(t, w)["id"]
+	require.NotEmpty(t, id)
+	return id
+}
+
+func TestGetTaskByID(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	id := addTestTask(t, service, `{"date":"`+today+`","title":"Купить хлеб","comment":"белый","repeat":"d 2"}`)
+
+	w := serve(service.GetTaskByID, http.MethodGet, "/api/task?id="+id, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	task := decode(t, w)
+	assert.Equal(t, id, task["id"])
+	assert.Equal(t, today, task["date"])
+	assert.Equal(t, "Купить хлеб", task["title"])
+	assert.Equal(t, "белый", task["comment"])
+	assert.Equal(t, "d 2", task["repeat"])
+
+	for _, target := range []string{"/api/task", "/api/task?id=100500", "/api/task?id=abc"} {
+		w = serve(service.GetTaskByID, http.MethodGet, target, "")
+		assert.Equal(t, http.StatusNotFound, w.Code, target)
+		assert.NotEmpty(t, decode(t, w)["error"], target)
+	}
+}
+
+// The legacy route returns the same fields as /api/v1/tasks/{id}.
+func TestGetTaskByIDFull(t *testing.T) {
+	service, store := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	project, err := store.AddProject(daterules.Project{Name: "Дом"})
+	require.NoError(t, err)
+	projectID := strconv.FormatInt(project, 10)
+	blocker := addTestTask(t, service, `{"date":"`+today+`","title":"Купить краску"}`)
+	id := addTestTask(t, service, `{"date":"`+today+`","title":"Покрасить забор","project_id":"`+projectID+`"}`)
+	w := serve(service.Dependencies, http.MethodPost, "/api/task/depends", `{"id":"`+id+`","depends_on":"`+blocker+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(service.GetTaskByID, http.MethodGet, "/api/task?id="+id, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var task daterules.Task
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	assert.Equal(t, projectID, task.ProjectID)
+	assert.Equal(t, []string{blocker}, task.BlockedBy)
+	assert.NotEmpty(t, task.CreatedAt)
+
+	v1 := httptest.NewRecorder()
+	newV1Router(service).ServeHTTP(v1, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/"+id, nil))
+	require.Equal(t, http.StatusOK, v1.Code)
+	assert.JSONEq(t, v1.Body.String(), w.Body.String())
+}
+
+func TestEditAndDeleteTask(t *testing.T) {
+	service, store := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	id := addTestTask(t, service, `{"date":"`+today+`","title":"Позвонить"}`)
+
+	w := serve(service.UpdateTask, http.MethodPut, "/api/task", `{"id":"`+id+`","date":"`+today+`","title":"Перезвонить"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task, err := store.GetEntry(id)
+	require.NoError(t, err)
+	assert.Equal(t, "Перезвонить", task.Title)
+
+	w = serve(service.UpdateTask, http.MethodPut, "/api/task", `{"id":"100500","date":"`+today+`","title":"Нет"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+id, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = store.GetEntry(id)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	w = serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+id, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDoneTask(t *testing.T) {
+	service, store := newTestService(t)
+	now := time.Now()
+	once := addTestTask(t, service, `{"date":"`+now.Format(TimeFormat)+`","title":"Один раз"}`)
+	weekly := addTestTask(t, service, `{"date":"`+now.Format(TimeFormat)+`","title":"Каждую неделю","repeat":"d 7"}`)
+
+	w := serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+once, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err := store.GetEntry(once)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+weekly, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task, err := store.GetEntry(weekly)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 7).Format(TimeFormat), task.Date)
+
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+once, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPatchTask(t *testing.T) {
+	service, store := newTestService(t)
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(TimeFormat)
+	id := addTestTask(t, service, `{"title":"Отчёт","comment":"до обеда","repeat":"d 3"}`)
+
+	w := serve(service.PatchTask, http.MethodPatch, "/api/task?id="+id, `{"date":"`+tomorrow+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task, err := store.GetEntry(id)
+	require.NoError(t, err)
+	assert.Equal(t, tomorrow, task.Date)
+	assert.Equal(t, "Отчёт", task.Title)
+	assert.Equal(t, "до обеда", task.Comment)
+	assert.Equal(t, "d 3", task.Repeat)
+
+	w = serve(service.PatchTask, http.MethodPatch, "/api/task?id="+id, `{"comment":null,"title":"Сдать отчёт"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task, err = store.GetEntry(id)
+	require.NoError(t, err)
+	assert.Equal(t, "", task.Comment)
+	assert.Equal(t, "Сдать отчёт", task.Title)
+	assert.Equal(t, tomorrow, task.Date)
+
+	for _, patch := range []string{
+		`{"title":null}`,
+		`{"title":""}`,
+		`{"date":"20240230"}`,
+		`{"repeat":"x 1"}`,
+		`{"title":7}`,
+		`{"created_at":"20240101"}`,
+		`{"id":"100500"}`,
+		`[]`,
+		`null`,
+	} {
+		w = serve(service.PatchTask, http.MethodPatch, "/api/task?id="+id, patch)
+		assert.Equal(t, http.StatusBadRequest, w.Code, patch)
+	}
+	after, err := store.GetEntry(id)
+	require.NoError(t, err)
+	assert.Equal(t, task, after)
+
+	w = serve(service.PatchTask, http.MethodPatch, "/api/task?id=100500", `{"title":"Нет"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	overdue, err := store.AddEntry(daterules.Task{Date: "20240101", Title: "Просрочено"})
+	require.NoError(t, err)
+	w = serve(service.PatchTask, http.MethodPatch, "/api/task?id="+strconv.FormatInt(overdue, 10), `{"title":"Всё ещё просрочено"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task, err = store.GetEntry(strconv.FormatInt(overdue, 10))
+	require.NoError(t, err)
+	assert.Equal(t, "20240101", task.Date)
+}
+
+func TestBatchTasks(t *testing.T) {
+	service, store := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	keep := addTestTask(t, service, `{"date":"`+today+`","title":"Оставить"}`)
+	done := addTestTask(t, service, `{"date":"`+today+`","title":"Выполнить"}`)
+
+	results := func(w *httptest.ResponseRecorder) []batchResult {
+		var resp struct {
+			Results []batchResult `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+		return resp.Results
+	}
+
+	w := serve(service.BatchTasks, http.MethodPost, "/api/tasks/batch", `{"operations":[
+		{"op":"create","task":{"date":"`+today+`","title":"Новая"}},
+		{"op":"update","task":{"id":"`+keep+`","date":"`+today+`","title":"Изменена"}},
+		{"op":"done","id":"`+done+`"},
+		{"op":"delete","id":"100500"}
+	]}`)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	res := results(w)
+	require.Len(t, res, 4)
+	assert.Equal(t, []int{424, 424, 424, 404}, []int{res[0].Status, res[1].Status, res[2].Status, res[3].Status})
+	assert.NotEmpty(t, res[3].Error)
+	tasks, err := store.GetAllEntries(database.Filter{})
+	require.NoError(t, err)
+	assert.Len(t, tasks, 2)
+	task, err := store.GetEntry(keep)
+	require.NoError(t, err)
+	assert.Equal(t, "Оставить", task.Title)
+
+	w = serve(service.BatchTasks, http.MethodPost, "/api/tasks/batch", `{"mode":"continue","operations":[
+		{"op":"create","task":{"date":"`+today+`","title":"Новая"}},
+		{"op":"update","id":"`+keep+`","task":{"date":"`+today+`","title":""}},
+		{"op":"update","id":"`+keep+`","if_match":"\"1\"","task":{"date":"`+today+`","title":"Изменена"}},
+		{"op":"done","id":"`+done+`"},
+		{"op":"archive","id":"`+keep+`"}
+	]}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	res = results(w)
+	require.Len(t, res, 5)
+	assert.Equal(t, []int{200, 400, 200, 200, 400}, []int{res[0].Status, res[1].Status, res[2].Status, res[3].Status, res[4].Status})
+	assert.Equal(t, 2, res[2].Version)
+	assert.NotZero(t, res[3].UndoID)
+
+	_, err = store.GetEntry(res[0].ID)
+	assert.NoError(t, err)
+	task, err = store.GetEntry(keep)
+	require.NoError(t, err)
+	assert.Equal(t, "Изменена", task.Title)
+	_, err = store.GetEntry(done)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	for _, body := range []string{`{"operations":[]}`, `{"mode":"maybe","operations":[{"op":"delete","id":"1"}]}`, `[]`} {
+		w = serve(service.BatchTasks, http.MethodPost, "/api/tasks/batch", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+// Concurrent batches wait for each other instead of failing on the
+// database lock.
+func TestBatchConcurrent(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	ids := make([]string, 30)
+	for i := range ids {
+		ids[i] = addTestTask(t, service, `{"date":"`+today+`","title":"Задача"}`)
+	}
+
+	codes := make([]int, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serve(service.BatchTasks, http.MethodPost, "/api/tasks/batch", `{"operations":[
+				{"op":"update","task":{"id":"`+id+`","date":"`+today+`","title":"Изменена"}}
+			]}`)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		assert.Equal(t, http.StatusOK, code, ids[i])
+	}
+}
+
+func TestRepeatRules(t *testing.T) {
+	service, _ := newTestService(t)
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(TimeFormat)
+	id := addTestTask(t, service, `{"date":"`+tomorrow+`","title":"Отчёт","repeat":"d 3"}`)
+
+	w := serve(NextDeadLine, http.MethodGet, "/api/nextdate?now=20240126&date=20240101&repeat=d%205", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "20240126", w.Body.String())
+
+	// Rules that used to panic or never end must be rejected everywhere.
+	for _, repeat := range []string{" ", "yd", "dy 1", "d", "d 0", "d 367", "d x", "y 1", "w 1"} {
+		w = serve(NextDeadLine, http.MethodGet, "/api/nextdate?now=20240126&date=20200101&repeat="+url.QueryEscape(repeat), "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, repeat)
+
+		for _, date := range []string{"20200101", tomorrow} {
+			w = serve(service.CreateTask, http.MethodPost, "/api/task", `{"date":"`+date+`","title":"Отчёт","repeat":"`+repeat+`"}`)
+			assert.Equal(t, http.StatusBadRequest, w.Code, repeat)
+		}
+		w = serve(service.PatchTask, http.MethodPatch, "/api/task?id="+id, `{"repeat":"`+repeat+`"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, repeat)
+		w = serve(service.PatchTask, http.MethodPatch, "/api/task?id="+id, `{"date":"20200101","repeat":"`+repeat+`"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, repeat)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	// The routes are laid out as in main: the web files at the root and
+	// the API in its own subrouter.
+	r := chi.NewRouter()
+	r.MethodNotAllowed(MethodNotAllowed)
+	r.Get("/*", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("<html>")) })
+	r.Route("/api", func(r chi.Router) {
+		r.NotFound(NotFound)
+		r.MethodNotAllowed(MethodNotAllowed)
+		r.Get("/trash", http.NotFound)
+		r.Delete("/trash", http.NotFound)
+	})
+	do := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := do(http.MethodPatch, "/api/trash")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, DELETE", w.Header().Get("Allow"))
+	assert.NotEmpty(t, decode(t, w)["error"])
+
+	// Unknown API paths get the JSON error, not the web files.
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w = do(method, "/api/nonexistent")
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+		assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"), method)
+		assert.NotEmpty(t, decode(t, w)["error"], method)
+	}
+	assert.Equal(t, "<html>", do(http.MethodGet, "/index.html").Body.String())
+}
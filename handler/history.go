@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"final/daterules"
+)
+
+func (t TaskService) TaskHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := t.store(r).GetCompletions(r.FormValue("id"))
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+	writeHistory(w, history)
+}
+
+func (t TaskService) RecentHistory(w http.ResponseWriter, r *http.Request) {
+	count, _ := strconv.Atoi(r.FormValue("limit"))
+
+	history, err := t.store(r).RecentCompletions(count)
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+	writeHistory(w, history)
+}
+
+func writeHistory(w http.ResponseWriter, history []daterules.Completion) {
+	resp, err := json.Marshal(map[string]interface{}{
+		"history": history,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
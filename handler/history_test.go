@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func history(t *testing.T, service TaskService, target string) []daterules.Completion {
+	handler := service.RecentHistory
+	if target != "/api/history" {
+		handler = service.TaskHistory
+	}
+	w := serve(handler, http.MethodGet, target, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		History []daterules.Completion `json:"history"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.History
+}
+
+func TestCompletionHistory(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	task := addTestTask(t, service, `{"date":"`+today+`","title":"Зарядка","repeat":"d 1"}`)
+	once := addTestTask(t, service, `{"date":"`+today+`","title":"Разовая"}`)
+
+	w := serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+once, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	done := history(t, service, "/api/task/history?id="+task)
+	require.Len(t, done, 1)
+	assert.Equal(t, today, done[0].Date)
+	assert.Equal(t, time.Now().AddDate(0, 0, 1).Format(TimeFormat), done[0].NextDate)
+	assert.Equal(t, "Зарядка", done[0].Title)
+
+	done = history(t, service, "/api/task/history?id="+once)
+	require.Len(t, done, 1)
+	assert.Empty(t, done[0].NextDate)
+
+	assert.Len(t, history(t, service, "/api/history"), 2)
+}
+
+// A completion is recorded in the transaction that moves the task, so a
+// failed move leaves no completion behind.
+func TestCompletionRollback(t *testing.T) {
+	db := newTestDB(t)
+	store := database.NewContainer(db)
+	service := NewTaskService(store, Config{UndoWindow: time.Minute})
+	task := addTestTask(t, service, `{"date":"`+time.Now().Format(TimeFormat)+`","title":"Зарядка","repeat":"d 1"}`)
+
+	_, err := db.Exec(`CREATE TRIGGER fail_edit BEFORE UPDATE OF date ON scheduler
+	BEGIN
+		SELECT RAISE(ABORT, 'edit failed');
+	END`)
+	require.NoError(t, err)
+
+	w := serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+task, "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, history(t, service, "/api/task/history?id="+task))
+	assert.Empty(t, history(t, service, "/api/history"))
+	undo, err := store.GetUndoList(time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, undo)
+
+	_, err = db.Exec(`DROP TRIGGER fail_edit`)
+	require.NoError(t, err)
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Len(t, history(t, service, "/api/task/history?id="+task), 1)
+}
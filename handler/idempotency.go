@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"final/database"
+	"final/daterules"
+)
+
+// maxIdempotencyKey is the longest Idempotency-Key header accepted.
+const maxIdempotencyKey = 255
+
+// createIdempotent creates the task once per key and returns the response
+// to the first request, reporting whether it is replayed. Reusing the key
+// for another body is an error. Failed requests are not stored, so they
+// can be retried with the same key. It runs its own transaction, so it
+// publishes the new task itself.
+func (t TaskService) createIdempotent(store database.TaskContainer, key string, body []byte, task daterules.Task) (daterules.IdempotentResponse, bool, error) {
+	if len(key) > maxIdempotencyKey {
+		return daterules.IdempotentResponse{}, false, fail(http.StatusBadRequest, "Слишком длинный ключ идемпотентности")
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	var resp daterules.IdempotentResponse
+	var replay bool
+	var createdID string
+	err := store.InTx(func(store database.TaskContainer) error {
+		if err := store.PruneIdempotency(time.Now().Add(-t.config.IdempotencyTTL)); err != nil {
+			return err
+		}
+		var err error
+		resp, err = store.GetIdempotentResponse(key)
+		if err == nil {
+			replay = true
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		id, err := createTask(store, task)
+		if err != nil {
+			return err
+		}
+		createdID = strconv.FormatInt(id, 10)
+		resp = daterules.IdempotentResponse{
+			Key:         key,
+			RequestHash: hash,
+			Status:      http.StatusOK,
+			Body:        `{"id":"` + createdID + `"}`,
+		}
+		return store.AddIdempotentResponse(resp)
+	})
+	if errors.Is(err, database.ErrKeyTaken) {
+		// A concurrent request with the same key won; answer as its retry.
+		resp, err = store.GetIdempotentResponse(key)
+		replay = true
+	}
+	if err != nil {
+		return resp, false, err
+	}
+
+	if replay && resp.RequestHash != hash {
+		return resp, false, fail(http.StatusUnprocessableEntity, "Ключ идемпотентности уже использован для другого запроса")
+	}
+	if !replay {
+		t.publish(EventCreated, createdID)
+	}
+	return resp, replay, nil
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	service, store := newTestService(t)
+	service.config.IdempotencyTTL = time.Hour
+	today := time.Now().Format(TimeFormat)
+	body := `{"date":"` + today + `","title":"Купить молоко"}`
+
+	post := func(key string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/task", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", key)
+		service.CreateTask(w, r)
+		return w
+	}
+
+	w := post("retry-1", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	id := decode(t, w)["id"]
+
+	w = post("retry-1", body)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, id, decode(t, w)["id"])
+
+	w = post("retry-1", `{"date":"`+today+`","title":"Купить кефир"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+	w = post("retry-2", `{"date":"`+today+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post("retry-2", body)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(t, id, decode(t, w)["id"])
+
+	w = post(strings.Repeat("k", 256), body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	tasks, err := store.GetAllEntries(database.Filter{})
+	require.NoError(t, err)
+	assert.Len(t, tasks, 2)
+
+	err = store.AddIdempotentResponse(daterules.IdempotentResponse{Key: "retry-1", RequestHash: "x", Body: "{}"})
+	assert.ErrorIs(t, err, database.ErrKeyTaken)
+
+	// A negative TTL expires every stored response.
+	service.config.IdempotencyTTL = -time.Hour
+	w = post("retry-1", body)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	assert.NotEqual(t, id, decode(t, w)["id"])
+}
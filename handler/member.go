@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"final/database"
+	"final/daterules"
+)
+
+// Members shares a project with another user. Only the owner of the
+// project can see and change its members.
+func (t TaskService) Members(w http.ResponseWriter, r *http.Request) {
+	var member daterules.Member
+	if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	if member.Role != database.RoleViewer && member.Role != database.RoleEditor {
+		callErrorCode("Неверная роль", http.StatusBadRequest, w)
+		return
+	}
+
+	account, err := t.service.GetUserByLogin(member.Login)
+	if err != nil {
+		callErrorCode("Пользователь не найден", http.StatusNotFound, w)
+		return
+	}
+	if account.ID == currentUserID(r) {
+		callErrorCode("Нельзя открыть доступ самому себе", http.StatusBadRequest, w)
+		return
+	}
+	member.UserID = account.ID
+
+	if err := t.store(r).AddMember(member); err != nil {
+		callErrorCode("Проект не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (t TaskService) DeleteMember(w http.ResponseWriter, r *http.Request) {
+	err := t.store(r).DeleteMember(r.FormValue("project_id"), r.FormValue("user_id"))
+	if err != nil {
+		callErrorCode("Участник не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (t TaskService) GetMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := t.store(r).GetMembers(r.FormValue("project_id"))
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"members": members,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
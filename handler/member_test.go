@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sharedList struct {
+	service TaskService
+	store   database.TaskContainer
+	task    string
+	project string
+	users   map[string]int64
+}
+
+func newSharedList(t *testing.T) sharedList {
+	list := sharedList{users: map[string]int64{}}
+	list.service, list.store = newTestService(t)
+	for _, login := range []string{"owner", "viewer", "editor", "stranger"} {
+		id, err := list.store.AddUser(daterules.User{Login: login, PasswordHash: "-"})
+		require.NoError(t, err)
+		list.users[login] = id
+	}
+
+	owner := list.store.ForUser(list.users["owner"])
+	projectID, err := owner.AddProject(daterules.Project{Name: "team"})
+	require.NoError(t, err)
+	list.project = strconv.FormatInt(projectID, 10)
+	taskID, err := owner.AddEntry(daterules.Task{
+		Date:      time.Now().Format(TimeFormat),
+		Title:     "shared",
+		ProjectID: list.project,
+	})
+	require.NoError(t, err)
+	list.task = strconv.FormatInt(taskID, 10)
+
+	for _, role := range []string{database.RoleViewer, database.RoleEditor} {
+		require.NoError(t, owner.AddMember(daterules.Member{
+			ProjectID: list.project,
+			UserID:    strconv.FormatInt(list.users[role], 10),
+			Role:      role,
+		}))
+	}
+	return list
+}
+
+func (l sharedList) do(handler http.HandlerFunc, login string, method string, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), userKey{}, user{id: l.users[login], login: login}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func (l sharedList) taskExists(t *testing.T) bool {
+	_, _, err := l.store.ForUser(l.users["owner"]).TaskAccess(l.task)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	require.NoError(t, err)
+	return true
+}
+
+func TestSharedTaskForbidden(t *testing.T) {
+	list := newSharedList(t)
+	edit := `{"id":"` + list.task + `","date":"` + time.Now().Format(TimeFormat) + `","title":"changed"}`
+
+	w := list.do(list.service.UpdateTask, "viewer", http.MethodPut, "/api/task", edit)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = list.do(list.service.DeleteTask, "viewer", http.MethodDelete, "/api/task?id="+list.task, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = list.do(list.service.DoneTask, "viewer", http.MethodPost, "/api/task/done?id="+list.task, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = list.do(list.service.GetTaskByID, "stranger", http.MethodGet, "/api/task?id="+list.task, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = list.do(list.service.DeleteTask, "stranger", http.MethodDelete, "/api/task?id="+list.task, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = list.do(list.service.DoneTask, "stranger", http.MethodPost, "/api/task/done?id="+list.task, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.True(t, list.taskExists(t))
+}
+
+func TestSharedTaskAccess(t *testing.T) {
+	list := newSharedList(t)
+
+	for login, want := range map[string]string{
+		"owner":  database.RoleOwner,
+		"viewer": database.RoleViewer,
+		"editor": database.RoleEditor,
+	} {
+		owner, role, err := list.store.ForUser(list.users[login]).TaskAccess(list.task)
+		require.NoError(t, err, login)
+		assert.Equal(t, list.users["owner"], owner, login)
+		assert.Equal(t, want, role, login)
+	}
+
+	_, _, err := list.store.ForUser(list.users["stranger"]).TaskAccess(list.task)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	w := list.do(list.service.GetTaskByID, "viewer", http.MethodGet, "/api/task?id="+list.task, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"title":"shared"`)
+
+	edit := `{"id":"` + list.task + `","date":"` + time.Now().Format(TimeFormat) + `","title":"changed"}`
+	w = list.do(list.service.UpdateTask, "editor", http.MethodPut, "/api/task", edit)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	task, err := list.store.ForUser(list.users["owner"]).GetEntry(list.task)
+	require.NoError(t, err)
+	assert.Equal(t, "changed", task.Title)
+
+	w = list.do(list.service.DoneTask, "editor", http.MethodPost, "/api/task/done?id="+list.task, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.False(t, list.taskExists(t))
+}
+
+func TestMembersOwnerOnly(t *testing.T) {
+	list := newSharedList(t)
+
+	w := list.do(list.service.Members, "editor", http.MethodPost, "/api/projects/members",
+		`{"project_id":"`+list.project+`","login":"stranger","role":"editor"}`)
+	assert.Contains(t, w.Body.String(), "error")
+
+	w = list.do(list.service.DeleteMember, "editor", http.MethodDelete,
+		"/api/projects/members?project_id="+list.project+"&user_id="+strconv.FormatInt(list.users["viewer"], 10), "")
+	assert.Contains(t, w.Body.String(), "error")
+
+	_, _, err := list.store.ForUser(list.users["stranger"]).TaskAccess(list.task)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	w = list.do(list.service.DeleteMember, "owner", http.MethodDelete,
+		"/api/projects/members?project_id="+list.project+"&user_id="+strconv.FormatInt(list.users["viewer"], 10), "")
+	assert.Equal(t, "{}", w.Body.String())
+
+	_, _, err = list.store.ForUser(list.users["viewer"]).TaskAccess(list.task)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestSharedProjectList(t *testing.T) {
+	list := newSharedList(t)
+	today := time.Now().Format(TimeFormat)
+
+	for _, login := range []string{"owner", "viewer", "editor"} {
+		w := list.do(list.service.GetTasks, login, http.MethodGet, "/api/tasks", "")
+		assert.Contains(t, w.Body.String(), `"title":"shared"`, login)
+		w = list.do(list.service.GetProjects, login, http.MethodGet, "/api/projects", "")
+		assert.Contains(t, w.Body.String(), `"name":"team","count":1`, login)
+	}
+	w := list.do(list.service.GetTasks, "stranger", http.MethodGet, "/api/tasks", "")
+	assert.JSONEq(t, `{"tasks":[]}`, w.Body.String())
+	w = list.do(list.service.GetProjects, "stranger", http.MethodGet, "/api/projects", "")
+	assert.JSONEq(t, `{"projects":[]}`, w.Body.String())
+
+	// A task added to the project by a member belongs to its owner.
+	add := `{"date":"` + today + `","title":"added","project_id":"` + list.project + `"}`
+	w = list.do(list.service.CreateTask, "viewer", http.MethodPost, "/api/task", add)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = list.do(list.service.CreateTask, "stranger", http.MethodPost, "/api/task", add)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = list.do(list.service.CreateTask, "editor", http.MethodPost, "/api/task", add)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	owner, _, err := list.store.ForUser(list.users["editor"]).TaskAccess(decode(t, w)["id"])
+	require.NoError(t, err)
+	assert.Equal(t, list.users["owner"], owner)
+	w = list.do(list.service.GetTasks, "owner", http.MethodGet, "/api/tasks?project_id="+list.project, "")
+	assert.Contains(t, w.Body.String(), `"title":"added"`)
+
+	// Members cannot move their own tasks into the project.
+	w = list.do(list.service.CreateTask, "editor", http.MethodPost, "/api/task", `{"date":"`+today+`","title":"own"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	own := decode(t, w)["id"]
+	w = list.do(list.service.MoveTask, "editor", http.MethodPost, "/api/task/move?id="+own+"&project_id="+list.project, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestSharedTaskUndo(t *testing.T) {
+	list := newSharedList(t)
+	w := list.do(list.service.DoneTask, "editor", http.MethodPost, "/api/task/done?id="+list.task, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.False(t, list.taskExists(t))
+
+	// The completion is the editor's, but the owner sees it too.
+	for _, login := range []string{"editor", "owner"} {
+		w = list.do(list.service.TaskHistory, login, http.MethodGet, "/api/task/history?id="+list.task, "")
+		assert.Contains(t, w.Body.String(), `"title":"shared"`, login)
+	}
+	w = list.do(list.service.TaskHistory, "stranger", http.MethodGet, "/api/task/history?id="+list.task, "")
+	assert.JSONEq(t, `{"history":[]}`, w.Body.String())
+	w = list.do(list.service.RecentHistory, "owner", http.MethodGet, "/api/history", "")
+	assert.JSONEq(t, `{"history":[]}`, w.Body.String())
+
+	// Only the editor can undo the editor's change.
+	w = list.do(list.service.Undo, "owner", http.MethodPost, "/api/undo", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = list.do(list.service.Undo, "editor", http.MethodPost, "/api/undo", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, list.taskExists(t))
+	w = list.do(list.service.TaskHistory, "owner", http.MethodGet, "/api/task/history?id="+list.task, "")
+	assert.JSONEq(t, `{"history":[]}`, w.Body.String())
+
+	// The role is checked again when the change is undone.
+	edit := `{"id":"` + list.task + `","date":"` + time.Now().Format(TimeFormat) + `","title":"changed"}`
+	w = list.do(list.service.UpdateTask, "editor", http.MethodPut, "/api/task", edit)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, list.store.ForUser(list.users["owner"]).AddMember(daterules.Member{
+		ProjectID: list.project,
+		UserID:    strconv.FormatInt(list.users["editor"], 10),
+		Role:      database.RoleViewer,
+	}))
+	w = list.do(list.service.Undo, "editor", http.MethodPost, "/api/undo", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	task, err := list.store.ForUser(list.users["owner"]).GetEntry(list.task)
+	require.NoError(t, err)
+	assert.Equal(t, "changed", task.Title)
+}
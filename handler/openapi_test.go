@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spec is the parsed OpenAPI document with a validator for the subset of
+// JSON Schema it uses.
+type spec map[string]any
+
+func loadSpec(t *testing.T) spec {
+	var s spec
+	require.NoError(t, json.Unmarshal(openAPI, &s))
+	return s
+}
+
+// resolve follows a local $ref such as #/components/schemas/Task.
+func (s spec) resolve(node map[string]any) map[string]any {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var cur any = map[string]any(s)
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			cur = cur.(map[string]any)[part]
+		}
+		node = cur.(map[string]any)
+	}
+}
+
+func (s spec) operations() []string {
+	var ops []string
+	for path, item := range s["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// response returns the documented schema of the response to an operation
+// and its media type.
+func (s spec) response(method, path string, code int) (map[string]any, string, error) {
+	item, ok := s["paths"].(map[string]any)[path].(map[string]any)
+	if !ok {
+		return nil, "", fmt.Errorf("path %s is not documented", path)
+	}
+	op, ok := item[strings.ToLower(method)].(map[string]any)
+	if !ok {
+		return nil, "", fmt.Errorf("%s %s is not documented", method, path)
+	}
+	resp, ok := op["responses"].(map[string]any)[fmt.Sprint(code)].(map[string]any)
+	if !ok {
+		return nil, "", fmt.Errorf("status %d of %s %s is not documented", code, method, path)
+	}
+	for media, content := range s.resolve(resp)["content"].(map[string]any) {
+		return s.resolve(content.(map[string]any)["schema"].(map[string]any)), media, nil
+	}
+	return nil, "", fmt.Errorf("response %d of %s %s has no content", code, method, path)
+}
+
+func (s spec) validate(schema map[string]any, value any, at string) []string {
+	schema = s.resolve(schema)
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + ": null is not allowed"}
+	}
+
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{at + ": want an object"}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := obj[name.(string)]; !ok {
+					errs = append(errs, fmt.Sprintf("%s: missing %s", at, name))
+				}
+			}
+		}
+		for name, v := range obj {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					errs = append(errs, fmt.Sprintf("%s: unexpected %s", at, name))
+				}
+				continue
+			}
+			errs = append(errs, s.validate(prop, v, at+"."+name)...)
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return []string{at + ": want an array"}
+		}
+		for i, v := range arr {
+			errs = append(errs, s.validate(schema["items"].(map[string]any), v, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{at + ": want a string"}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			errs = append(errs, fmt.Sprintf("%s: %q does not match %s", at, str, pattern))
+		}
+		if enum, ok := schema["enum"].([]any); ok {
+			found := false
+			for _, e := range enum {
+				found = found || e == str
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf("%s: %q is not one of %v", at, str, enum))
+			}
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && len([]rune(str)) > int(maxLength) {
+			errs = append(errs, fmt.Sprintf("%s: longer than %v", at, maxLength))
+		}
+	case "integer", "number":
+		if _, ok := value.(float64); !ok {
+			return []string{at + ": want a number"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{at + ": want a boolean"}
+		}
+	}
+	return errs
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := loadSpec(t)
+	service, store := newTestService(t)
+	service.config.IdempotencyTTL = time.Hour
+	today := time.Now().Format(TimeFormat)
+
+	id := addTestTask(t, service, `{"date":"`+today+`","title":"Помыть окна","repeat":"d 7"}`)
+	curtains := addTestTask(t, service, `{"date":"`+today+`","title":"Купить шторы"}`)
+	blocked := addTestTask(t, service, `{"date":"`+today+`","title":"Повесить шторы"}`)
+	require.NoError(t, store.AddDependency(blocked, curtains))
+
+	w := serve(service.APITokens, http.MethodPost, "/api/tokens", `{"name":"spec","scopes":["read"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	readToken := decode(t, w)["token"]
+	readOnly := service.Auth(http.HandlerFunc(service.CreateTask)).ServeHTTP
+	limited := NewTaskService(store, Config{IPRate: 0.001, IPBurst: 1})
+	limitedTasks := limited.RateLimit(http.HandlerFunc(limited.GetTasks)).ServeHTTP
+
+	cases := []struct {
+		method  string
+		path    string
+		query   string
+		header  string
+		body    string
+		handler http.HandlerFunc
+		code    int
+	}{
+		{"GET", "/api/nextdate", "?now=20240126&date=20240126&repeat=d%207", "", "", NextDeadLine, 200},
+		{"GET", "/api/nextdate", "?now=20240126&date=20240126&repeat=x", "", "", NextDeadLine, 400},
+
+		{"POST", "/api/task", "", "Idempotency-Key: spec", `{"title":"Вынести мусор"}`, service.CreateTask, 200},
+		{"POST", "/api/task", "", "Idempotency-Key: spec", `{"title":"Вынести хлам"}`, service.CreateTask, 422},
+		{"POST", "/api/task", "", "", `{"date":"` + today + `"}`, service.CreateTask, 400},
+		{"POST", "/api/task", "", "", `{"title":"В проект","project_id":"100500"}`, service.CreateTask, 404},
+		{"POST", "/api/task", "", "Authorization: Bearer " + readToken, `{"title":"Без прав"}`, readOnly, 403},
+
+		{"GET", "/api/task", "?id=" + id, "", "", service.GetTaskByID, 200},
+		{"GET", "/api/task", "?id=100500", "", "", service.GetTaskByID, 404},
+
+		{"PUT", "/api/task", "", `If-Match: "1"`, `{"id":"` + id + `","date":"` + today + `","title":"Помыть окна","repeat":"d 7"}`, service.UpdateTask, 200},
+		{"PUT", "/api/task", "", `If-Match: "1"`, `{"id":"` + id + `","date":"` + today + `","title":"Помыть окна"}`, service.UpdateTask, 412},
+		{"PUT", "/api/task", "", "", `{"id":"` + id + `","date":"` + today + `","title":""}`, service.UpdateTask, 400},
+		{"PUT", "/api/task", "", "", `{"id":"100500","date":"` + today + `","title":"Нет"}`, service.UpdateTask, 404},
+
+		{"PATCH", "/api/task", "?id=" + id, "", `{"comment":"и рамы"}`, service.PatchTask, 200},
+		{"PATCH", "/api/task", "?id=" + id, "", `{"version":5}`, service.PatchTask, 400},
+
+		{"POST", "/api/task/done", "?id=" + blocked, "", "", service.DoneTask, 409},
+		{"POST", "/api/task/done", "?id=" + id, "", "", service.DoneTask, 200},
+		{"POST", "/api/task/done", "?id=100500", "", "", service.DoneTask, 404},
+
+		{"GET", "/api/tasks", "", "", "", service.GetTasks, 200},
+		{"GET", "/api/tasks", "?sort=title", "", "", service.GetTasks, 400},
+		{"GET", "/api/tasks", "", "", "", limitedTasks, 200},
+		{"GET", "/api/tasks", "", "", "", limitedTasks, 429},
+
+		{"DELETE", "/api/task", "?id=" + blocked, "", "", service.DeleteTask, 200},
+		{"DELETE", "/api/task", "?id=" + blocked, "", "", service.DeleteTask, 404},
+	}
+
+	seen := map[string]bool{}
+	for _, c := range cases {
+		name := c.method + " " + c.path + c.query
+		seen[c.method+" "+c.path] = true
+
+		r := httptest.NewRequest(c.method, c.path+c.query, strings.NewReader(c.body))
+		if key, value, ok := strings.Cut(c.header, ": "); ok {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		c.handler(w, r)
+		require.Equal(t, c.code, w.Code, "%s: %s", name, w.Body.String())
+
+		schema, media, err := s.response(c.method, c.path, w.Code)
+		require.NoError(t, err, name)
+		var value any = w.Body.String()
+		if media == "application/json" {
+			assert.Contains(t, w.Header().Get("Content-Type"), "application/json", name)
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &value), name)
+		}
+		assert.Empty(t, s.validate(schema, value, "body"), "%s: %s", name, w.Body.String())
+	}
+
+	service.config.RequireIfMatch = true
+	w = serve(service.UpdateTask, http.MethodPut, "/api/task", `{"id":"`+id+`","date":"`+today+`","title":"Без версии"}`)
+	require.Equal(t, http.StatusPreconditionRequired, w.Code)
+	_, _, err := s.response("PUT", "/api/task", w.Code)
+	assert.NoError(t, err)
+
+	for _, op := range s.operations() {
+		assert.True(t, seen[op], "%s is documented but not checked", op)
+	}
+}
+
+func TestOpenAPIRefs(t *testing.T) {
+	s := loadSpec(t)
+	refs := regexp.MustCompile(`"\$ref":\s*"([^"]+)"`).FindAllSubmatch(openAPI, -1)
+	require.NotEmpty(t, refs)
+	for _, ref := range refs {
+		assert.NotPanics(t, func() { s.resolve(map[string]any{"$ref": string(ref[1])}) }, string(ref[1]))
+	}
+
+	w := serve(OpenAPI, http.MethodGet, "/api/openapi.json", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, string(openAPI), w.Body.String())
+}
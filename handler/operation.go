@@ -0,0 +1,373 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"final/database"
+	"final/daterules"
+)
+
+// opError is a failed task operation together with the status it is
+// answered with.
+type opError struct {
+	code int
+	msg  string
+}
+
+func (e opError) Error() string {
+	return e.msg
+}
+
+func fail(code int, msg string) error {
+	return opError{code: code, msg: msg}
+}
+
+// errorStatus returns the status and message a failed operation is
+// answered with. Errors other than opError are storage failures.
+func errorStatus(err error) (int, string) {
+	var e opError
+	if errors.As(err, &e) {
+		return e.code, e.msg
+	}
+	return http.StatusInternalServerError, "Ошибка базы данных"
+}
+
+func writeOpError(w http.ResponseWriter, err error) {
+	code, msg := errorStatus(err)
+	callErrorCode(msg, code, w)
+}
+
+// outcome is the result of a task operation: the new version of the task,
+// zero when the task is gone, and the entry to undo the operation with.
+type outcome struct {
+	id      string
+	version int
+	undoID  int64
+}
+
+func writeOutcome(w http.ResponseWriter, out outcome) {
+	if out.version > 0 {
+		w.Header().Set("ETag", etag(out.version))
+	}
+	if out.undoID > 0 {
+		w.Header().Set("X-Undo-Id", strconv.FormatInt(out.undoID, 10))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// taskOwner returns the owner of the task, so that the members of a
+// shared project can reach it. Viewers may only read.
+func taskOwner(store database.TaskContainer, id string, write bool) (int64, error) {
+	owner, role, err := store.TaskAccess(id)
+	if err != nil {
+		return 0, fail(http.StatusNotFound, "Задача не найдена")
+	}
+	if write && role == database.RoleViewer {
+		return 0, fail(http.StatusForbidden, "Недостаточно прав")
+	}
+	return owner, nil
+}
+
+// ownerStore returns the storage of the owner of the task.
+func ownerStore(store database.TaskContainer, id string, write bool) (database.TaskContainer, error) {
+	owner, err := taskOwner(store, id, write)
+	if err != nil {
+		return store, err
+	}
+	return store.ForUser(owner), nil
+}
+
+func loadTask(store database.TaskContainer, id string) (daterules.Task, error) {
+	task, err := store.GetEntry(id)
+	if errors.Is(err, database.ErrNotFound) {
+		return task, fail(http.StatusNotFound, "Задача не найдена")
+	}
+	return task, err
+}
+
+// getTask reads a task the user owns or shares.
+func getTask(store database.TaskContainer, id string) (daterules.Task, error) {
+	store, err := ownerStore(store, id, false)
+	if err != nil {
+		return daterules.Task{}, err
+	}
+	return loadTask(store, id)
+}
+
+// validateTask checks a task sent in full, as by POST and PUT.
+func validateTask(task *daterules.Task) error {
+	if task.Title == "" {
+		return fail(http.StatusBadRequest, "Не указан заголовок задачи")
+	}
+	if msg := checkDate(task); msg != "" {
+		return fail(http.StatusBadRequest, msg)
+	}
+	return nil
+}
+
+// createTask adds a task. A task created in a shared project belongs to
+// the owner of the project, like the rest of its tasks.
+func createTask(store database.TaskContainer, task daterules.Task) (int64, error) {
+	if err := validateTask(&task); err != nil {
+		return 0, err
+	}
+	if task.ProjectID != "" {
+		owner, role, err := store.ProjectAccess(task.ProjectID)
+		if err != nil {
+			return 0, fail(http.StatusNotFound, "Проект не найден")
+		}
+		if role == database.RoleViewer {
+			return 0, fail(http.StatusForbidden, "Недостаточно прав")
+		}
+		store = store.ForUser(owner)
+	}
+	return store.AddEntry(task)
+}
+
+// updateTask replaces the editable fields of a task, checking the version
+// given in match as the If-Match header.
+func (t TaskService) updateTask(store database.TaskContainer, task daterules.Task, match string) (outcome, error) {
+	if err := validateTask(&task); err != nil {
+		return outcome{}, err
+	}
+	owner, err := taskOwner(store, task.ID, true)
+	if err != nil {
+		return outcome{}, err
+	}
+	before, err := loadTask(store.ForUser(owner), task.ID)
+	if err != nil {
+		return outcome{}, err
+	}
+	task.Version, err = t.matchVersion(match, before.Version)
+	if err != nil {
+		return outcome{}, err
+	}
+	return t.saveTask(store, owner, before, task)
+}
+
+// patchTask applies a JSON Merge Patch (RFC 7396) to a task. Only the
+// changed fields are checked, so a task whose date has passed can be
+// renamed without moving it.
+func (t TaskService) patchTask(store database.TaskContainer, id string, patch map[string]json.RawMessage, match string) (outcome, error) {
+	if patch == nil {
+		return outcome{}, fail(http.StatusBadRequest, "Изменения должны быть JSON-объектом")
+	}
+	owner, err := taskOwner(store, id, true)
+	if err != nil {
+		return outcome{}, err
+	}
+	before, err := loadTask(store.ForUser(owner), id)
+	if err != nil {
+		return outcome{}, err
+	}
+	version, err := t.matchVersion(match, before.Version)
+	if err != nil {
+		return outcome{}, err
+	}
+
+	task := before
+	task.Version = version
+	fields := map[string]*string{
+		"date":    &task.Date,
+		"title":   &task.Title,
+		"comment": &task.Comment,
+		"repeat":  &task.Repeat,
+	}
+	for name, value := range patch {
+		if name == "id" && string(value) == `"`+task.ID+`"` {
+			continue
+		}
+		field, ok := fields[name]
+		if !ok {
+			return outcome{}, fail(http.StatusBadRequest, "Поле "+name+" нельзя изменить")
+		}
+		// A null removes the member, which for a task means an empty
+		// value.
+		*field = ""
+		if string(value) == "null" {
+			continue
+		}
+		if err := json.Unmarshal(value, field); err != nil {
+			return outcome{}, fail(http.StatusBadRequest, "неверное значение поля "+name)
+		}
+	}
+
+	if _, ok := patch["title"]; ok && task.Title == "" {
+		return outcome{}, fail(http.StatusBadRequest, "Не указан заголовок задачи")
+	}
+	_, newDate := patch["date"]
+	_, newRepeat := patch["repeat"]
+	if newRepeat && task.Repeat != "" {
+		if _, err := daterules.NextTime(time.Now(), task.Date, task.Repeat); err != nil {
+			return outcome{}, fail(http.StatusBadRequest, "неверный формат")
+		}
+	}
+	if newDate || newRepeat {
+		if msg := checkDate(&task); msg != "" {
+			return outcome{}, fail(http.StatusBadRequest, msg)
+		}
+	}
+
+	return t.saveTask(store, owner, before, task)
+}
+
+// saveTask writes the edited task of owner over before, recording the
+// undo entry for the user of store. The write checks task.Version unless
+// it is 0.
+func (t TaskService) saveTask(store database.TaskContainer, owner int64, before daterules.Task, task daterules.Task) (outcome, error) {
+	var undoID int64
+	var version int
+	err := store.InTx(func(store database.TaskContainer) error {
+		err := store.ForUser(owner).EditEntry(task)
+		if err != nil {
+			return err
+		}
+		version, err = store.ForUser(owner).StoredVersion(task.ID)
+		if err != nil {
+			return err
+		}
+		undoID, err = t.record(store, database.UndoEdit, before, version, 0)
+		return err
+	})
+	if errors.Is(err, database.ErrVersionConflict) {
+		return outcome{}, fail(http.StatusPreconditionFailed, "Задача была изменена другим пользователем")
+	}
+	if errors.Is(err, database.ErrRepeatingBlocker) {
+		return outcome{}, fail(http.StatusBadRequest, "Повторяющаяся задача не может блокировать другие задачи")
+	}
+	if err != nil {
+		return outcome{}, fail(http.StatusInternalServerError, "ошибка подключения к базе данных")
+	}
+
+	return outcome{id: task.ID, version: version, undoID: undoID}, nil
+}
+
+// doneTask completes a task: a one-off task is closed, a repeating one
+// moves to its next date. Unless force is set, tasks waiting for others
+// cannot be completed. The completion and the undo entry belong to the
+// user who completed the task.
+func (t TaskService) doneTask(store database.TaskContainer, id string, match string, force bool) (outcome, error) {
+	now, _ := time.Parse(TimeFormat, time.Now().Format(TimeFormat))
+
+	owner, err := taskOwner(store, id, true)
+	if err != nil {
+		return outcome{}, err
+	}
+	task, err := loadTask(store.ForUser(owner), id)
+	if err != nil {
+		return outcome{}, err
+	}
+	version, err := t.matchVersion(match, task.Version)
+	if err != nil {
+		return outcome{}, err
+	}
+
+	if !force {
+		blockedBy, err := store.ForUser(owner).Blockers(task.ID)
+		if err != nil {
+			return outcome{}, err
+		}
+		if len(blockedBy) > 0 {
+			return outcome{}, fail(http.StatusConflict, "Задача ожидает выполнения задач: "+strings.Join(blockedBy, ", "))
+		}
+	}
+
+	before := task
+	task.Version = version
+	completion := daterules.Completion{
+		TaskID: task.ID,
+		Title:  task.Title,
+		Date:   task.Date,
+		DoneAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if task.Repeat != "" {
+		next, err := daterules.NextTime(now, task.Date, task.Repeat)
+		if err != nil {
+			return outcome{}, fail(http.StatusInternalServerError, "не получилось найти следующую дату")
+		}
+		task.Date = next
+		completion.NextDate = task.Date
+	}
+
+	var undoID int64
+	err = store.InTx(func(store database.TaskContainer) error {
+		if err := completeTask(store.ForUser(owner), task); err != nil {
+			return err
+		}
+		version, err = store.ForUser(owner).StoredVersion(task.ID)
+		if err != nil {
+			return err
+		}
+		completionID, err := store.AddCompletion(completion)
+		if err != nil {
+			return err
+		}
+		undoID, err = t.record(store, database.UndoDone, before, version, completionID)
+		return err
+	})
+	if errors.Is(err, database.ErrVersionConflict) {
+		return outcome{}, fail(http.StatusPreconditionFailed, "Задача была изменена другим пользователем")
+	}
+	if err != nil {
+		return outcome{}, fail(http.StatusInternalServerError, "не получилось отметить задачу выполненной")
+	}
+
+	out := outcome{id: task.ID, undoID: undoID}
+	if task.Repeat != "" {
+		out.version = version
+	}
+	return out, nil
+}
+
+// completeTask closes a one-off task or moves a repeating one to the date
+// already set in task. The version is checked unless it is 0.
+func completeTask(store database.TaskContainer, task daterules.Task) error {
+	if task.Repeat == "" {
+		if task.Version != 0 {
+			if err := store.CheckVersion(task.ID, task.Version); err != nil {
+				return err
+			}
+		}
+		return store.CompleteEntry(task.ID)
+	}
+	if err := store.EditEntry(task); err != nil {
+		return err
+	}
+	return store.ResetChecklist(task.ID)
+}
+
+func (t TaskService) deleteTask(store database.TaskContainer, id string) (outcome, error) {
+	owner, err := taskOwner(store, id, true)
+	if err != nil {
+		return outcome{}, err
+	}
+	task, err := loadTask(store.ForUser(owner), id)
+	if err != nil {
+		return outcome{}, err
+	}
+
+	var undoID int64
+	err = store.InTx(func(store database.TaskContainer) error {
+		err := store.ForUser(owner).DeleteEntry(task.ID)
+		if err != nil {
+			return err
+		}
+		version, err := store.ForUser(owner).StoredVersion(task.ID)
+		if err != nil {
+			return err
+		}
+		undoID, err = t.record(store, database.UndoDelete, task, version, 0)
+		return err
+	})
+	if err != nil {
+		return outcome{}, fail(http.StatusInternalServerError, "не получилось удалить задачу")
+	}
+
+	return outcome{id: task.ID, undoID: undoID}, nil
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// PatchTask applies a JSON Merge Patch (RFC 7396) to the task given by id.
+func (t TaskService) PatchTask(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	out, err := t.patchTask(t.store(r), r.FormValue("id"), patch, r.Header.Get("If-Match"))
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	t.publish(EventUpdated, out.id)
+	writeOutcome(w, out)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"final/database"
+	"final/daterules"
+)
+
+func (t TaskService) Projects(w http.ResponseWriter, r *http.Request) {
+	var project daterules.Project
+	if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	if project.Name == "" {
+		callErrorCode("Не указано название проекта", http.StatusBadRequest, w)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		if err := t.store(r).EditProject(project); err != nil {
+			callErrorCode("Проект не найден", http.StatusNotFound, w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+
+	id, err := t.store(r).AddProject(project)
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]string{"id": strconv.Itoa(int(id))})
+	if err != nil {
+		callErrorCode("не получилось создать проект", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) GetProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := t.store(r).GetAllProjects()
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"projects": projects,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	if err := t.store(r).DeleteProject(id); err != nil {
+		callErrorCode("Проект не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (t TaskService) MoveTask(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	projectID := r.FormValue("project_id")
+
+	// Tasks are moved only between the projects of their owner.
+	if projectID != "" && projectID != "0" {
+		_, role, err := t.store(r).ProjectAccess(projectID)
+		if err != nil {
+			callErrorCode("Проект не найден", http.StatusNotFound, w)
+			return
+		}
+		if role != database.RoleOwner {
+			callErrorCode("Недостаточно прав", http.StatusForbidden, w)
+			return
+		}
+	}
+
+	if err := t.store(r).MoveEntry(id, projectID); err != nil {
+		callErrorCode("Задача не найдена", http.StatusNotFound, w)
+		return
+	}
+	t.publish(EventUpdated, id)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
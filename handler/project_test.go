@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func projectList(t *testing.T, service TaskService) map[string]daterules.Project {
+	w := serve(service.GetProjects, http.MethodGet, "/api/projects", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Projects []daterules.Project `json:"projects"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	projects := map[string]daterules.Project{}
+	for _, p := range resp.Projects {
+		projects[p.ID] = p
+	}
+	return projects
+}
+
+func TestProjects(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+
+	w := serve(service.Projects, http.MethodPost, "/api/projects", `{"name":""}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(service.Projects, http.MethodPost, "/api/projects", `{"name":"Работа"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	work := decode(t, w)["id"]
+	w = serve(service.Projects, http.MethodPost, "/api/projects", `{"name":"Дом"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	home := decode(t, w)["id"]
+
+	first := addTestTask(t, service, `{"date":"`+today+`","title":"Отчёт","project_id":"`+work+`"}`)
+	addTestTask(t, service, `{"date":"`+today+`","title":"Созвон","project_id":"`+work+`"}`)
+	loose := addTestTask(t, service, `{"date":"`+today+`","title":"Без проекта"}`)
+
+	w = serve(service.CreateTask, http.MethodPost, "/api/task", `{"date":"`+today+`","title":"x","project_id":"999"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	projects := projectList(t, service)
+	assert.Equal(t, 2, projects[work].Count)
+	assert.Equal(t, 0, projects[home].Count)
+
+	// Moving a task changes the counts and the project filter.
+	w = serve(service.MoveTask, http.MethodPost, "/api/task/move?id="+first+"&project_id="+home, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(service.MoveTask, http.MethodPost, "/api/task/move?id="+loose+"&project_id=999", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(service.MoveTask, http.MethodPost, "/api/task/move?id=999&project_id="+home, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	projects = projectList(t, service)
+	assert.Equal(t, 1, projects[work].Count)
+	assert.Equal(t, 1, projects[home].Count)
+
+	w = serve(service.GetTasks, http.MethodGet, "/api/tasks?project_id="+home, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Tasks []daterules.Task `json:"tasks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Tasks, 1)
+	assert.Equal(t, first, list.Tasks[0].ID)
+	assert.Equal(t, home, list.Tasks[0].ProjectID)
+	w = serve(service.GetTasks, http.MethodGet, "/api/tasks?project_id=дом", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Deleting a project keeps its tasks outside of any project.
+	w = serve(service.DeleteProject, http.MethodDelete, "/api/projects?id="+work, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(service.DeleteProject, http.MethodDelete, "/api/projects?id="+work, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, projectList(t, service), work)
+
+	w = serve(service.GetTasks, http.MethodGet, "/api/tasks", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Tasks, 3)
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit throttles the requests of every client address.
+func (t TaskService) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := t.ipLimit.Allow(clientIP(r)); !ok {
+			tooManyRequests(wait, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AccountRateLimit throttles the requests of every signed-in user, from
+// whatever address they come. It must run after Auth.
+func (t TaskService) AccountRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := currentUser(r).id; id != 0 {
+			if ok, wait := t.userLimit.Allow(strconv.FormatInt(id, 10)); !ok {
+				tooManyRequests(wait, w)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkLockout writes the error response and returns false while the
+// sign-ins of the key are locked.
+func (t TaskService) checkLockout(w http.ResponseWriter, key string) bool {
+	if wait := t.lockout.Locked(key); wait > 0 {
+		tooManyRequests(wait, w)
+		return false
+	}
+	return true
+}
+
+func tooManyRequests(wait time.Duration, w http.ResponseWriter) {
+	seconds := max(int(math.Ceil(wait.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	callErrorCode("Слишком много запросов, попробуйте позже", http.StatusTooManyRequests, w)
+}
@@ -0,0 +1,296 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"final/database"
+	"final/daterules"
+	"final/events"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxSocketMessage is the largest message read from a socket.
+const maxSocketMessage = 1 << 20
+
+// socketMessage is a message of the client. Every message but subscribe
+// and unsubscribe changes a task; ref is echoed in the answer so the
+// client can match it.
+type socketMessage struct {
+	Type      string          `json:"type"`
+	Ref       string          `json:"ref"`
+	ProjectID string          `json:"project_id"`
+	ID        string          `json:"id"`
+	Task      *daterules.Task `json:"task"`
+	IfMatch   string          `json:"if_match"`
+	Force     bool            `json:"force"`
+}
+
+// socketReply is an ack or error answering a message, or an event of a
+// subscription.
+type socketReply struct {
+	Type    string          `json:"type"`
+	Ref     string          `json:"ref,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"version,omitempty"`
+	UndoID  int64           `json:"undo_id,omitempty"`
+	Status  int             `json:"status,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	EventID int64           `json:"event_id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type socket struct {
+	conn  *websocket.Conn
+	user  user
+	addr  string
+	store database.TaskContainer
+	// request is the opening request, whose credential is checked again
+	// before every change.
+	request *http.Request
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	// projects are the subscribed projects; the empty id stands for all
+	// the tasks the user can see.
+	projects map[string]bool
+}
+
+// Socket serves a WebSocket connection over which the client subscribes
+// to the changes of its tasks and changes them with the same checks as
+// the HTTP API.
+func (t TaskService) Socket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s := &socket{
+		conn:     conn,
+		user:     currentUser(r),
+		addr:     clientIP(r),
+		store:    t.store(r),
+		request:  r,
+		projects: map[string]bool{},
+	}
+	done := make(chan struct{})
+	sub, _, _ := t.bus.Subscribe("")
+	go s.forward(sub, done)
+
+	heartbeat := t.config.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	go s.ping(heartbeat, done)
+	// The client has to send something, at least a pong, in every three
+	// heartbeats.
+	idle := func(string) error { return conn.SetReadDeadline(time.Now().Add(3 * heartbeat)) }
+	conn.SetPongHandler(idle)
+	conn.SetReadLimit(maxSocketMessage)
+
+	for {
+		_ = idle("")
+		op, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if op != websocket.TextMessage {
+			s.reply(socketReply{Type: "error", Status: http.StatusBadRequest, Error: "Ожидается текстовое сообщение"})
+			continue
+		}
+		var msg socketMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.reply(socketReply{Type: "error", Status: http.StatusBadRequest, Error: err.Error()})
+			continue
+		}
+		t.handleSocket(s, msg)
+	}
+
+	close(done)
+	sub.Close()
+	s.close(websocket.CloseNormalClosure, "")
+}
+
+// upgrader lets a browser connect only from a page of the same host,
+// since it sends its cookies along.
+var upgrader = websocket.Upgrader{
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		if status == http.StatusForbidden {
+			callErrorCode("Подключение с другого сайта запрещено", http.StatusForbidden, w)
+			return
+		}
+		callErrorCode("Ожидается подключение WebSocket", http.StatusBadRequest, w)
+	},
+}
+
+func (t TaskService) handleSocket(s *socket, msg socketMessage) {
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		if msg.ProjectID != "" {
+			if _, _, err := s.store.ProjectAccess(msg.ProjectID); err != nil {
+				s.fail(msg, fail(http.StatusNotFound, "Проект не найден"))
+				return
+			}
+		}
+		s.mu.Lock()
+		if msg.Type == "subscribe" {
+			s.projects[msg.ProjectID] = true
+		} else {
+			delete(s.projects, msg.ProjectID)
+		}
+		s.mu.Unlock()
+		s.reply(socketReply{Type: "ack", Ref: msg.Ref})
+		return
+	case "create", "update", "done", "delete":
+	default:
+		s.fail(msg, fail(http.StatusBadRequest, "Неизвестный тип сообщения "+msg.Type))
+		return
+	}
+
+	if err := t.reauthorize(s); err != nil {
+		s.fail(msg, err)
+		s.close(websocket.ClosePolicyViolation, "credential revoked")
+		return
+	}
+	if s.user.readOnly {
+		s.fail(msg, fail(http.StatusForbidden, "Токен не даёт права на запись"))
+		return
+	}
+	if !t.allowSocket(s) {
+		s.fail(msg, fail(http.StatusTooManyRequests, "Слишком много запросов, попробуйте позже"))
+		return
+	}
+	if (msg.Type == "create" || msg.Type == "update") && msg.Task == nil {
+		s.fail(msg, fail(http.StatusBadRequest, "Не указана задача"))
+		return
+	}
+
+	var out outcome
+	var err error
+	switch msg.Type {
+	case "create":
+		var id int64
+		id, err = createTask(s.store, *msg.Task)
+		out = outcome{id: strconv.FormatInt(id, 10), version: 1}
+	case "update":
+		if msg.Task.ID == "" {
+			msg.Task.ID = msg.ID
+		}
+		out, err = t.updateTask(s.store, *msg.Task, msg.IfMatch)
+	case "done":
+		out, err = t.doneTask(s.store, msg.ID, msg.IfMatch, msg.Force)
+	case "delete":
+		out, err = t.deleteTask(s.store, msg.ID)
+	}
+	if err != nil {
+		s.fail(msg, err)
+		return
+	}
+
+	s.reply(socketReply{Type: "ack", Ref: msg.Ref, ID: out.id, Version: out.version, UndoID: out.undoID})
+	t.publish(socketEvents[msg.Type], out.id)
+}
+
+// reauthorize checks the credential the socket was opened with again, so
+// that a revoked API token or a changed password stops the changes made
+// over sockets that are already open. Anonymous sockets have nothing to
+// revoke.
+func (t TaskService) reauthorize(s *socket) error {
+	u, found, err := t.requestUser(s.request)
+	if found && (err != nil || u.id != s.user.id) {
+		return fail(http.StatusUnauthorized, "Требуется аутентификация")
+	}
+	return nil
+}
+
+// allowSocket counts a change sent over the socket against the same
+// limits as an HTTP request: the one of the client address and, for a
+// signed-in user, the one of the account.
+func (t TaskService) allowSocket(s *socket) bool {
+	if ok, _ := t.ipLimit.Allow(s.addr); !ok {
+		return false
+	}
+	if s.user.id != 0 {
+		if ok, _ := t.userLimit.Allow(strconv.FormatInt(s.user.id, 10)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// socketEvents are the events published for the changes made over a
+// socket.
+var socketEvents = map[string]string{
+	"create": EventCreated,
+	"update": EventUpdated,
+	"done":   EventDone,
+	"delete": EventDeleted,
+}
+
+func (s *socket) wants(e events.Event) bool {
+	if !e.VisibleTo(s.user.id) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.projects[""] || (e.Project != "" && s.projects[e.Project])
+}
+
+// forward sends the subscribed events until the subscription ends. A
+// client that falls behind is disconnected to resync.
+func (s *socket) forward(sub *events.Subscription, done <-chan struct{}) {
+	for e := range sub.Events() {
+		if s.wants(e) {
+			s.reply(socketReply{Type: "event", Event: e.Type, EventID: e.ID, Data: e.Data})
+		}
+	}
+	select {
+	case <-done:
+	default:
+		s.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+func (s *socket) ping(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *socket) fail(msg socketMessage, err error) {
+	code, text := errorStatus(err)
+	s.reply(socketReply{Type: "error", Ref: msg.Ref, ID: msg.ID, Status: code, Error: text})
+}
+
+func (s *socket) reply(reply socketReply) {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// close sends the close frame and drops the connection, which ends the
+// read loop.
+func (s *socket) close(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	_ = s.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	_ = s.conn.Close()
+}
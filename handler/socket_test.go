@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSocket struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialSocket(t *testing.T, service TaskService, u user) testSocket {
+	return openSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service.Socket(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	}), nil)
+}
+
+func openSocket(t *testing.T, handler http.Handler, header http.Header) testSocket {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return testSocket{t: t, conn: conn}
+}
+
+func (s testSocket) send(msg string) socketReply {
+	require.NoError(s.t, s.conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+	return s.read()
+}
+
+func (s testSocket) read() socketReply {
+	require.NoError(s.t, s.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, data, err := s.conn.ReadMessage()
+	require.NoError(s.t, err)
+	var reply socketReply
+	require.NoError(s.t, json.Unmarshal(data, &reply), string(data))
+	return reply
+}
+
+func TestSocket(t *testing.T) {
+	service, store := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	s := dialSocket(t, service, user{})
+
+	reply := s.send(`{"type":"subscribe","ref":"1"}`)
+	assert.Equal(t, socketReply{Type: "ack", Ref: "1"}, reply)
+
+	reply = s.send(`{"type":"create","ref":"2","task":{"date":"` + today + `","title":"Купить билеты","repeat":"d 7"}}`)
+	require.Equal(t, "ack", reply.Type, reply.Error)
+	assert.Equal(t, "2", reply.Ref)
+	assert.Equal(t, 1, reply.Version)
+	id := reply.ID
+
+	event := s.read()
+	assert.Equal(t, "event", event.Type)
+	assert.Equal(t, EventCreated, event.Event)
+	var data taskEvent
+	require.NoError(t, json.Unmarshal(event.Data, &data))
+	assert.Equal(t, id, data.ID)
+	require.NotNil(t, data.Task)
+	assert.Equal(t, "Купить билеты", data.Task.Title)
+
+	reply = s.send(`{"type":"update","ref":"3","id":"` + id + `","if_match":"\"5\"","task":{"date":"` + today + `","title":"Купить билеты"}}`)
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, "3", reply.Ref)
+	assert.Equal(t, http.StatusPreconditionFailed, reply.Status)
+
+	reply = s.send(`{"type":"update","ref":"4","id":"` + id + `","task":{"date":"` + today + `","title":""}}`)
+	assert.Equal(t, http.StatusBadRequest, reply.Status)
+
+	reply = s.send(`{"type":"update","ref":"5","id":"` + id + `","if_match":"\"1\"","task":{"date":"` + today + `","title":"Купить билеты в театр","repeat":"d 7"}}`)
+	require.Equal(t, "ack", reply.Type, reply.Error)
+	assert.Equal(t, 2, reply.Version)
+	assert.NotZero(t, reply.UndoID)
+	assert.Equal(t, EventUpdated, s.read().Event)
+
+	reply = s.send(`{"type":"done","ref":"6","id":"` + id + `"}`)
+	require.Equal(t, "ack", reply.Type, reply.Error)
+	assert.Equal(t, EventDone, s.read().Event)
+
+	// Changes made over HTTP reach the socket too.
+	other := addTestTask(t, service, `{"date":"`+today+`","title":"Позвонить маме"}`)
+	event = s.read()
+	assert.Equal(t, EventCreated, event.Event)
+
+	reply = s.send(`{"type":"delete","ref":"7","id":"` + other + `"}`)
+	require.Equal(t, "ack", reply.Type, reply.Error)
+	event = s.read()
+	assert.Equal(t, EventDeleted, event.Event)
+	assert.JSONEq(t, `{"id":"`+other+`"}`, string(event.Data))
+
+	for _, msg := range []string{
+		`{"type":"delete","id":"100500"}`,
+		`{"type":"subscribe","project_id":"100500"}`,
+		`{"type":"create"}`,
+		`{"type":"archive"}`,
+		`не json`,
+	} {
+		reply = s.send(msg)
+		assert.Equal(t, "error", reply.Type, msg)
+		assert.NotZero(t, reply.Status, msg)
+	}
+
+	// Only the subscribed project is sent after unsubscribing from all.
+	project, err := store.AddProject(daterules.Project{Name: "Дом"})
+	require.NoError(t, err)
+	projectID := strconv.FormatInt(project, 10)
+	assert.Equal(t, "ack", s.send(`{"type":"unsubscribe"}`).Type)
+	assert.Equal(t, "ack", s.send(`{"type":"subscribe","project_id":"`+projectID+`"}`).Type)
+	addTestTask(t, service, `{"date":"`+today+`","title":"Вне проекта"}`)
+	addTestTask(t, service, `{"date":"`+today+`","title":"В проекте","project_id":"`+projectID+`"}`)
+	require.NoError(t, json.Unmarshal(s.read().Data, &data))
+	assert.Equal(t, "В проекте", data.Task.Title)
+}
+
+func TestSocketReadOnly(t *testing.T) {
+	service, _ := newTestService(t)
+	s := dialSocket(t, service, user{readOnly: true})
+
+	assert.Equal(t, "ack", s.send(`{"type":"subscribe"}`).Type)
+	reply := s.send(`{"type":"create","ref":"1","task":{"title":"Нельзя"}}`)
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, http.StatusForbidden, reply.Status)
+}
+
+func TestSocketRevokedToken(t *testing.T) {
+	service, _ := newTestService(t)
+	w := serve(service.APITokens, http.MethodPost, "/api/tokens", `{"name":"socket","scopes":["read","write"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	token := decode(t, w)
+
+	header := http.Header{"Authorization": {"Bearer " + token["token"]}}
+	s := openSocket(t, service.Auth(http.HandlerFunc(service.Socket)), header)
+	create := `{"type":"create","ref":"1","task":{"title":"Пока можно"}}`
+	require.Equal(t, "ack", s.send(create).Type)
+
+	w = serve(service.DeleteAPIToken, http.MethodDelete, "/api/tokens?id="+token["id"], "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	reply := s.send(create)
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, http.StatusUnauthorized, reply.Status)
+
+	// The socket is closed after that.
+	_, _, err := s.conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), err)
+}
+
+func TestSocketHandshake(t *testing.T) {
+	service, _ := newTestService(t)
+	w := serve(service.Socket, http.MethodGet, "/api/ws", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	server := httptest.NewServer(http.HandlerFunc(service.Socket))
+	t.Cleanup(server.Close)
+	header := http.Header{"Origin": {"http://example.com"}}
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestSocketRateLimit(t *testing.T) {
+	store := database.NewContainer(newTestDB(t))
+	service := NewTaskService(store, Config{UndoWindow: time.Minute, IPRate: 0.001, IPBurst: 2})
+	create := `{"type":"create","ref":"1","task":{"title":"Спам"}}`
+
+	// Anonymous sockets are limited by the client address.
+	s := dialSocket(t, service, user{})
+	for range 2 {
+		assert.Equal(t, "ack", s.send(create).Type)
+	}
+	reply := s.send(create)
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, http.StatusTooManyRequests, reply.Status)
+
+	// A new connection from the same address does not reset the limit.
+	reply = dialSocket(t, service, user{}).send(create)
+	assert.Equal(t, http.StatusTooManyRequests, reply.Status)
+
+	service = NewTaskService(store, Config{UndoWindow: time.Minute, AccountRate: 0.001, AccountBurst: 1})
+	s = dialSocket(t, service, user{id: 7, login: "anna"})
+	assert.Equal(t, "ack", s.send(create).Type)
+	assert.Equal(t, http.StatusTooManyRequests, s.send(create).Status)
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortTasks(t *testing.T) {
+	db := newTestDB(t)
+	service := NewTaskService(database.NewContainer(db), Config{UndoWindow: time.Minute})
+	date := func(days int) string {
+		return time.Now().AddDate(0, 0, days).Format(TimeFormat)
+	}
+	a := addTestTask(t, service, `{"date":"`+date(2)+`","title":"A"}`)
+	b := addTestTask(t, service, `{"date":"`+date(3)+`","title":"B"}`)
+	c := addTestTask(t, service, `{"date":"`+date(1)+`","title":"C"}`)
+
+	// Timestamps have a resolution of a second, so they are set apart here.
+	for id, stamps := range map[string][2]string{
+		a: {"2024-01-01T10:00:00Z", "2024-03-01T10:00:00Z"},
+		b: {"2024-01-03T10:00:00Z", "2024-01-04T10:00:00Z"},
+		c: {"2024-01-02T10:00:00Z", "2024-02-01T10:00:00Z"},
+	} {
+		_, err := db.Exec(`UPDATE scheduler SET created_at = ?, updated_at = ? WHERE id = ?`, stamps[0], stamps[1], id)
+		require.NoError(t, err)
+	}
+
+	list := func(query string) []string {
+		w := serve(service.GetTasks, http.MethodGet, "/api/tasks?"+query, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp struct {
+			Tasks []daterules.Task `json:"tasks"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		var titles []string
+		for _, task := range resp.Tasks {
+			titles = append(titles, task.Title)
+		}
+		return titles
+	}
+	assert.Equal(t, []string{"C", "A", "B"}, list(""))
+	assert.Equal(t, []string{"B", "A", "C"}, list("sort=date&order=desc"))
+	assert.Equal(t, []string{"A", "C", "B"}, list("sort=created_at"))
+	assert.Equal(t, []string{"B", "C", "A"}, list("sort=created_at&order=desc"))
+	assert.Equal(t, []string{"B", "C", "A"}, list("sort=updated_at"))
+	assert.Equal(t, []string{"A", "C", "B"}, list("sort=updated_at&order=desc"))
+
+	w := serve(service.GetTasks, http.MethodGet, "/api/tasks?sort=title", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(service.ActionableTasks, http.MethodGet, "/api/tasks/actionable?sort=id", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+func (t TaskService) Trash(w http.ResponseWriter, r *http.Request) {
+	tasks, err := t.store(r).GetTrash()
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"tasks": tasks,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) RestoreTask(w http.ResponseWriter, r *http.Request) {
+	if err := t.store(r).RestoreEntry(r.FormValue("id")); err != nil {
+		callErrorCode("Задача не найдена в корзине", http.StatusNotFound, w)
+		return
+	}
+	t.publish(EventCreated, r.FormValue("id"))
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// PurgeTrash removes the task given by id from the trash, or empties the
+// whole trash when no id is given.
+func (t TaskService) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	if id == "" {
+		if _, err := t.store(r).PurgeTrash(time.Now()); err != nil {
+			callErrorCode("не получилось очистить корзину", http.StatusInternalServerError, w)
+			return
+		}
+	} else if err := t.store(r).PurgeEntry(id); err != nil {
+		callErrorCode("Задача не найдена в корзине", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func trash(t *testing.T, service TaskService) []string {
+	w := serve(service.Trash, http.MethodGet, "/api/trash", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Tasks []daterules.Task `json:"tasks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+
+	var ids []string
+	for _, task := range resp.Tasks {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestDoneTaskNotInTrash(t *testing.T) {
+	db := newTestDB(t)
+	store := database.NewContainer(db)
+	service := NewTaskService(store, Config{UndoWindow: time.Minute})
+	today := time.Now().Format(TimeFormat)
+	deleted := addTestTask(t, service, `{"date":"`+today+`","title":"Удалённая"}`)
+	done := addTestTask(t, service, `{"date":"`+today+`","title":"Выполненная"}`)
+
+	w := serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+deleted, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+done, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	undoID := w.Header().Get("X-Undo-Id")
+
+	assert.Equal(t, []string{deleted}, trash(t, service))
+	w = serve(service.RestoreTask, http.MethodPost, "/api/trash/restore?id="+done, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(service.PurgeTrash, http.MethodDelete, "/api/trash?id="+deleted, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, trash(t, service))
+	w = serve(service.PurgeTrash, http.MethodDelete, "/api/trash?id="+done, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// The completed task survives emptying the trash until the completion
+	// can no longer be undone.
+	w = serve(service.Undo, http.MethodPost, "/api/undo?id="+undoID, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(service.GetTaskByID, http.MethodGet, "/api/task?id="+done, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, history(t, service, "/api/task/history?id="+done))
+
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+done, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, store.PurgeCompleted(time.Now().Add(time.Minute)))
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM scheduler WHERE id = ?`, done).Scan(&count))
+	assert.Zero(t, count)
+	assert.Len(t, history(t, service, "/api/task/history?id="+done), 1)
+}
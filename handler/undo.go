@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"final/database"
+	"final/daterules"
+)
+
+// record writes the state of a task before a mutating call to the undo
+// journal, dropping the entries that have already expired together with
+// the completed tasks they could bring back. The version is the one the
+// call left the task at, so that undo can tell whether the task was
+// changed since.
+func (t TaskService) record(store database.TaskContainer, operation string, before daterules.Task, version int, completionID int64) (int64, error) {
+	if err := store.PruneUndo(t.undoSince()); err != nil {
+		return 0, err
+	}
+	if err := store.PurgeCompleted(t.undoSince()); err != nil {
+		return 0, err
+	}
+
+	return store.AddUndo(daterules.UndoEntry{
+		Operation:    operation,
+		TaskID:       before.ID,
+		Before:       before,
+		Version:      version,
+		CompletionID: strconv.FormatInt(completionID, 10),
+	})
+}
+
+func (t TaskService) undoSince() time.Time {
+	return time.Now().Add(-t.config.UndoWindow)
+}
+
+func (t TaskService) Undo(w http.ResponseWriter, r *http.Request) {
+	entry, err := t.store(r).GetUndo(r.FormValue("id"), t.undoSince())
+	if err != nil {
+		callErrorCode("Нет операции для отмены", http.StatusNotFound, w)
+		return
+	}
+
+	// The entry belongs to the user who made the change, while the task
+	// may belong to the owner of a shared project, and the user's role
+	// there may have changed since.
+	owner, role, err := t.store(r).StoredTaskAccess(entry.TaskID)
+	if err != nil {
+		callErrorCode("Задача не найдена", http.StatusNotFound, w)
+		return
+	}
+	if role == database.RoleViewer {
+		callErrorCode("Недостаточно прав", http.StatusForbidden, w)
+		return
+	}
+
+	err = t.store(r).InTx(func(store database.TaskContainer) error {
+		if err := undoTask(store.ForUser(owner), entry); err != nil {
+			return err
+		}
+		if entry.Operation == database.UndoDone {
+			if err := store.DeleteCompletion(entry.CompletionID); err != nil {
+				return err
+			}
+		}
+		return store.DeleteUndo(entry.ID)
+	})
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		callErrorCode("Задача не найдена", http.StatusNotFound, w)
+		return
+	case errors.Is(err, database.ErrVersionConflict):
+		callErrorCode("Задача была изменена после этой операции", http.StatusConflict, w)
+		return
+	case errors.Is(err, database.ErrRepeatingBlocker):
+		callErrorCode("Повторяющаяся задача не может блокировать другие задачи", http.StatusConflict, w)
+		return
+	case err != nil:
+		callErrorCode("не получилось отменить операцию", http.StatusInternalServerError, w)
+		return
+	}
+	// A deleted or completed one-off task comes back to the lists.
+	if entry.Operation == database.UndoDelete || entry.Operation == database.UndoDone && entry.Before.Repeat == "" {
+		t.publish(EventCreated, entry.TaskID)
+	} else {
+		t.publish(EventUpdated, entry.TaskID)
+	}
+
+	resp, err := json.Marshal(map[string]string{
+		"id":        entry.ID,
+		"operation": entry.Operation,
+		"task_id":   entry.TaskID,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+// undoTask brings the task back to its state before the operation of the
+// entry, unless it has been changed since. Entries recorded without a
+// version are not checked.
+func undoTask(store database.TaskContainer, entry daterules.UndoEntry) error {
+	var version int
+	var err error
+	// Edits and repeating completions leave the task in the lists, the
+	// other operations take it out of them.
+	live := entry.Operation == database.UndoEdit || entry.Operation == database.UndoDone && entry.Before.Repeat != ""
+	if live {
+		var task daterules.Task
+		task, err = store.GetEntry(entry.TaskID)
+		version = task.Version
+	} else {
+		version, err = store.StoredVersion(entry.TaskID)
+	}
+	if err != nil {
+		return err
+	}
+	if entry.Version != 0 && version != entry.Version {
+		return database.ErrVersionConflict
+	}
+
+	switch {
+	case entry.Operation == database.UndoDelete:
+		return store.RestoreEntry(entry.TaskID)
+	case entry.Operation == database.UndoDone && entry.Before.Repeat == "":
+		return store.ReopenEntry(entry.TaskID)
+	default:
+		before := entry.Before
+		before.Version = entry.Version
+		return store.EditEntry(before)
+	}
+}
+
+func (t TaskService) GetUndoList(w http.ResponseWriter, r *http.Request) {
+	entries, err := t.store(r).GetUndoList(t.undoSince())
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"operations": entries,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
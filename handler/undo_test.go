@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"final/daterules"
+	"final/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestTask(t *testing.T, service TaskService, id string) (daterules.Task, int) {
+	w := serve(service.GetTaskByID, http.MethodGet, "/api/task?id="+id, "")
+	var task daterules.Task
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	}
+	return task, w.Code
+}
+
+func TestUndo(t *testing.T) {
+	service, _ := newTestService(t)
+	today := time.Now().Format(TimeFormat)
+	task := addTestTask(t, service, `{"date":"`+today+`","title":"Полить цветы","repeat":"d 3"}`)
+
+	undo := func(id string) *httptest.ResponseRecorder {
+		return serve(service.Undo, http.MethodPost, "/api/undo?id="+id, "")
+	}
+
+	// Edit.
+	w := serve(service.UpdateTask, http.MethodPut, "/api/task", `{"id":"`+task+`","date":"`+today+`","title":"Полить кактус","repeat":"d 3"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = undo(w.Header().Get("X-Undo-Id"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "edit", decode(t, w)["operation"])
+	got, _ := getTestTask(t, service, task)
+	assert.Equal(t, "Полить цветы", got.Title)
+
+	// Done of a repeating task moves it back and drops the completion.
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	got, _ = getTestTask(t, service, task)
+	assert.NotEqual(t, today, got.Date)
+	w = undo(w.Header().Get("X-Undo-Id"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	got, _ = getTestTask(t, service, task)
+	assert.Equal(t, today, got.Date)
+	assert.Empty(t, history(t, service, "/api/task/history?id="+task))
+
+	// Delete.
+	w = serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	undoID := w.Header().Get("X-Undo-Id")
+	_, code := getTestTask(t, service, task)
+	assert.Equal(t, http.StatusNotFound, code)
+	w = undo(undoID)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, code = getTestTask(t, service, task)
+	assert.Equal(t, http.StatusOK, code)
+
+	// An entry is undone only once.
+	assert.Equal(t, http.StatusNotFound, undo(undoID).Code)
+
+	// Without an id the latest operation is undone.
+	once := addTestTask(t, service, `{"date":"`+today+`","title":"Разовая"}`)
+	w = serve(service.DoneTask, http.MethodPost, "/api/task/done?id="+once, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = undo("")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, once, decode(t, w)["task_id"])
+	_, code = getTestTask(t, service, once)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestUndoExpired(t *testing.T) {
+	service, _ := newTestService(t)
+	task := addTestTask(t, service, `{"date":"`+time.Now().Format(TimeFormat)+`","title":"Купить хлеб"}`)
+
+	w := serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	undoID := w.Header().Get("X-Undo-Id")
+
+	w = serve(service.GetUndoList, http.MethodGet, "/api/undo", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"task_id":"`+task+`"`)
+
+	// Once the window has passed the entry is no longer offered.
+	service.config.UndoWindow = -time.Second
+	w = serve(service.GetUndoList, http.MethodGet, "/api/undo", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"operations":[]}`, w.Body.String())
+	w = serve(service.Undo, http.MethodPost, "/api/undo?id="+undoID, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	_, code := getTestTask(t, service, task)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+// Undo does not overwrite later changes and does not fail on a task that is
+// gone.
+func TestUndoConflict(t *testing.T) {
+	service, _ := newTestService(t)
+	service.bus = events.NewBus(10)
+	server := httptest.NewServer(http.HandlerFunc(service.Events))
+	t.Cleanup(server.Close)
+	today := time.Now().Format(TimeFormat)
+	task := addTestTask(t, service, `{"date":"`+today+`","title":"Полить цветы"}`)
+	edit := func(title string) string {
+		w := serve(service.UpdateTask, http.MethodPut, "/api/task", `{"id":"`+task+`","date":"`+today+`","title":"`+title+`"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Header().Get("X-Undo-Id")
+	}
+	undo := func(id string) *httptest.ResponseRecorder {
+		return serve(service.Undo, http.MethodPost, "/api/undo?id="+id, "")
+	}
+
+	first := edit("Полить кактус")
+	edit("Полить фикус")
+	w := undo(first)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	got, _ := getTestTask(t, service, task)
+	assert.Equal(t, "Полить фикус", got.Title)
+
+	last := edit("Полить пальму")
+	w = serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	deleted := w.Header().Get("X-Undo-Id")
+	w = undo(last)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	// A restored task is announced as created, so that clients add it
+	// back.
+	messages := readEvents(t, server.URL, "")
+	w = undo(deleted)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, EventCreated, nextEvent(t, messages).event)
+
+	w = serve(service.DeleteTask, http.MethodDelete, "/api/task?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	deleted = w.Header().Get("X-Undo-Id")
+	w = serve(service.PurgeTrash, http.MethodDelete, "/api/trash?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = undo(deleted)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegister(t *testing.T) {
+	store := database.NewContainer(newTestDB(t))
+	body := `{"login":"anna","password":"пароль анны"}`
+
+	service := NewTaskService(store, Config{TokenTTL: time.Hour})
+	w := serve(service.Register, http.MethodPost, "/api/register", body)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	_, err := store.GetUserByLogin("anna")
+	assert.Error(t, err)
+
+	service = NewTaskService(store, Config{TokenTTL: time.Hour, AllowRegistration: true})
+	w = serve(service.Register, http.MethodPost, "/api/register", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEmpty(t, decode(t, w)["token"])
+	w = serve(service.Register, http.MethodPost, "/api/register", body)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = serve(service.Register, http.MethodPost, "/api/register", `{"login":"boris","password":"short"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// Each account sees and changes only its own tasks.
+func TestUserIsolation(t *testing.T) {
+	service, store := newTestService(t)
+	users := map[string]int64{}
+	for _, login := range []string{"anna", "boris"} {
+		id, err := store.AddUser(daterules.User{Login: login, PasswordHash: "-"})
+		require.NoError(t, err)
+		users[login] = id
+	}
+	as := func(login string, handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), userKey{}, user{id: users[login], login: login}))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	today := time.Now().Format(TimeFormat)
+	w := as("anna", service.CreateTask, http.MethodPost, "/api/task", `{"date":"`+today+`","title":"Задача Анны","repeat":"d 1"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	task := decode(t, w)["id"]
+
+	w = as("boris", service.GetTasks, http.MethodGet, "/api/tasks", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"tasks":[]}`, w.Body.String())
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"get":    as("boris", service.GetTaskByID, http.MethodGet, "/api/task?id="+task, ""),
+		"edit":   as("boris", service.UpdateTask, http.MethodPut, "/api/task", `{"id":"`+task+`","date":"`+today+`","title":"Чужая"}`),
+		"patch":  as("boris", service.PatchTask, http.MethodPatch, "/api/task?id="+task, `{"title":"Чужая"}`),
+		"done":   as("boris", service.DoneTask, http.MethodPost, "/api/task/done?id="+task, ""),
+		"delete": as("boris", service.DeleteTask, http.MethodDelete, "/api/task?id="+task, ""),
+	} {
+		assert.Equal(t, http.StatusNotFound, w.Code, name)
+	}
+	w = as("boris", service.Undo, http.MethodPost, "/api/undo", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = as("anna", service.GetTaskByID, http.MethodGet, "/api/task?id="+task, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Задача Анны")
+	assert.Contains(t, w.Body.String(), `"date":"`+today+`"`)
+	w = as("anna", service.GetTasks, http.MethodGet, "/api/tasks", "")
+	assert.Contains(t, w.Body.String(), "Задача Анны")
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"final/daterules"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// The /api/v1 routes address a task by its path, /api/v1/tasks/{id}, and
+// answer writes with the task itself. They run the same operations as the
+// legacy /api/task routes.
+
+func (t TaskService) CreateTaskV1(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+	var task daterules.Task
+	if err := json.Unmarshal(buf.Bytes(), &task); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	var id string
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		resp, replay, err := t.createIdempotent(t.store(r), key, buf.Bytes(), task)
+		if err != nil {
+			writeOpError(w, err)
+			return
+		}
+		var created map[string]string
+		if err := json.Unmarshal([]byte(resp.Body), &created); err != nil {
+			callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+			return
+		}
+		id = created["id"]
+		if replay {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+	} else {
+		n, err := createTask(t.store(r), task)
+		if err != nil {
+			writeOpError(w, err)
+			return
+		}
+		id = strconv.FormatInt(n, 10)
+		t.publish(EventCreated, id)
+	}
+
+	w.Header().Set("Location", "/api/v1/tasks/"+id)
+	t.writeTaskV1(w, r, http.StatusCreated, id)
+}
+
+func (t TaskService) GetTaskV1(w http.ResponseWriter, r *http.Request) {
+	t.writeTaskV1(w, r, http.StatusOK, chi.URLParam(r, "id"))
+}
+
+// UpdateTaskV1 replaces the task. An id in the body must match the path.
+func (t TaskService) UpdateTaskV1(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var task daterules.Task
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+	if task.ID != "" && task.ID != id {
+		callErrorCode("id в теле не совпадает с адресом задачи", http.StatusBadRequest, w)
+		return
+	}
+	task.ID = id
+
+	out, err := t.updateTask(t.store(r), task, r.Header.Get("If-Match"))
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	t.publish(EventUpdated, out.id)
+	t.writeOutcomeV1(w, r, out)
+}
+
+func (t TaskService) PatchTaskV1(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	out, err := t.patchTask(t.store(r), chi.URLParam(r, "id"), patch, r.Header.Get("If-Match"))
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	t.publish(EventUpdated, out.id)
+	t.writeOutcomeV1(w, r, out)
+}
+
+func (t TaskService) DeleteTaskV1(w http.ResponseWriter, r *http.Request) {
+	out, err := t.deleteTask(t.store(r), chi.URLParam(r, "id"))
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	t.publish(EventDeleted, out.id)
+	t.writeOutcomeV1(w, r, out)
+}
+
+// DoneTaskV1 answers with the task moved to its next date, or with 204
+// when a one-off task is gone.
+func (t TaskService) DoneTaskV1(w http.ResponseWriter, r *http.Request) {
+	out, err := t.doneTask(t.store(r), chi.URLParam(r, "id"), r.Header.Get("If-Match"), r.URL.Query().Get("force") == "true")
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	t.publish(EventDone, out.id)
+	t.writeOutcomeV1(w, r, out)
+}
+
+func (t TaskService) writeOutcomeV1(w http.ResponseWriter, r *http.Request, out outcome) {
+	if out.undoID > 0 {
+		w.Header().Set("X-Undo-Id", strconv.FormatInt(out.undoID, 10))
+	}
+	if out.version == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	t.writeTaskV1(w, r, http.StatusOK, out.id)
+}
+
+func (t TaskService) writeTaskV1(w http.ResponseWriter, r *http.Request, code int, id string) {
+	task, err := getTask(t.store(r), id)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+
+	resp, err := json.Marshal(task)
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("ETag", etag(task.Version))
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	_, _ = w.Write(resp)
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"final/daterules"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newV1Router(service TaskService) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/api/v1/tasks", service.CreateTaskV1)
+	r.Get("/api/v1/tasks/{id}", service.GetTaskV1)
+	r.Put("/api/v1/tasks/{id}", service.UpdateTaskV1)
+	r.Patch("/api/v1/tasks/{id}", service.PatchTaskV1)
+	r.Delete("/api/v1/tasks/{id}", service.DeleteTaskV1)
+	r.Post("/api/v1/tasks/{id}/done", service.DoneTaskV1)
+	return r
+}
+
+func TestTasksV1(t *testing.T) {
+	service, _ := newTestService(t)
+	router := newV1Router(service)
+	today := time.Now().Format(TimeFormat)
+
+	do := func(method, target, ifMatch, body string) (*httptest.ResponseRecorder, daterules.Task) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		router.ServeHTTP(w, r)
+		var task daterules.Task
+		if w.Code < 300 && w.Body.Len() > 0 {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task), w.Body.String())
+		}
+		return w, task
+	}
+
+	w, task := do(http.MethodPost, "/api/v1/tasks", "", `{"date":"`+today+`","title":"Полить цветы","repeat":"d 3"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "/api/v1/tasks/"+task.ID, w.Header().Get("Location"))
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Полить цветы", task.Title)
+	path := "/api/v1/tasks/" + task.ID
+
+	w, got := do(http.MethodGet, path, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, task, got)
+
+	// The legacy route reads the same task.
+	w = serve(service.GetTaskByID, http.MethodGet, "/api/task?id="+task.ID, "")
+	assert.Equal(t, "Полить цветы", decode(t, w)["title"])
+
+	w, _ = do(http.MethodPut, path, "", `{"id":"100500","date":"`+today+`","title":"Чужая"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, got = do(http.MethodPut, path, `"1"`, `{"date":"`+today+`","title":"Полить кактус","repeat":"d 3"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "Полить кактус", got.Title)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	assert.NotEmpty(t, w.Header().Get("X-Undo-Id"))
+
+	w, _ = do(http.MethodPatch, path, `"1"`, `{"comment":"немного"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w, got = do(http.MethodPatch, path, `"2"`, `{"comment":"немного"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "немного", got.Comment)
+
+	w, got = do(http.MethodPost, path+"/done", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	next, err := daterules.NextTime(time.Now(), today, "d 3")
+	require.NoError(t, err)
+	assert.Equal(t, next, got.Date)
+
+	w, _ = do(http.MethodDelete, path, "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("X-Undo-Id"))
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		w, _ = do(method, path, "", "")
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+
+	w, task = do(http.MethodPost, "/api/v1/tasks", "", `{"date":"`+today+`","title":"Разовая"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w, _ = do(http.MethodPost, "/api/v1/tasks/"+task.ID+"/done", "", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"final/daterules"
+)
+
+// deliveryLog is how many of the latest deliveries of a webhook are shown.
+const deliveryLog = 100
+
+var webhookEvents = []string{EventCreated, EventUpdated, EventDeleted, EventDone}
+
+// Webhooks subscribes a URL to events of the tasks the user can see. The
+// secret signing the payloads is generated unless given, and only shown
+// in this answer.
+func (t TaskService) Webhooks(w http.ResponseWriter, r *http.Request) {
+	var hook daterules.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		callErrorCode(err.Error(), http.StatusBadRequest, w)
+		return
+	}
+
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(hook.URL) > 2048 {
+		callErrorCode("Неверный адрес вебхука", http.StatusBadRequest, w)
+		return
+	}
+	if len(hook.Events) == 0 {
+		callErrorCode("Не указаны события вебхука", http.StatusBadRequest, w)
+		return
+	}
+	for _, event := range hook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			callErrorCode("Неизвестное событие "+event, http.StatusBadRequest, w)
+			return
+		}
+	}
+	if hook.Secret == "" {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			callErrorCode("не получилось создать секрет", http.StatusInternalServerError, w)
+			return
+		}
+		hook.Secret = hex.EncodeToString(raw)
+	}
+
+	id, err := t.store(r).AddWebhook(hook)
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]string{
+		"id":     strconv.FormatInt(id, 10),
+		"secret": hook.Secret,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := t.store(r).GetWebhooks()
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"webhooks": hooks,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+func (t TaskService) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := t.store(r).DeleteWebhook(r.FormValue("id")); err != nil {
+		callErrorCode("Вебхук не найден", http.StatusNotFound, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// WebhookDeliveries shows the latest deliveries of the webhook with the
+// outcome of their last attempt.
+func (t TaskService) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := r.FormValue("id")
+	if _, err := t.store(r).GetWebhook(id); err != nil {
+		callErrorCode("Вебхук не найден", http.StatusNotFound, w)
+		return
+	}
+	deliveries, err := t.store(r).GetDeliveries(id, deliveryLog)
+	if err != nil {
+		callErrorCode("Ошибка базы данных", http.StatusInternalServerError, w)
+		return
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{
+		"deliveries": deliveries,
+	})
+	if err != nil {
+		callErrorCode("Ошибка десериализации JSON", http.StatusInternalServerError, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(resp)
+}
+
+// DeliverWebhooks runs the worker delivering the queued events until the
+// context is done.
+func (t TaskService) DeliverWebhooks(ctx context.Context) {
+	t.hooks.Run(ctx)
+}
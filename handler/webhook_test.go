@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+	"final/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	service, _ := newTestService(t)
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com/hook","events":["created"]}`,
+		`{"url":"http:///hook","events":["created"]}`,
+		`{"url":"https://example.com/hook","events":[]}`,
+		`{"url":"https://example.com/hook","events":["created","moved"]}`,
+	} {
+		w := serve(service.Webhooks, http.MethodPost, "/api/webhooks", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	w := serve(service.Webhooks, http.MethodPost, "/api/webhooks",
+		`{"url":"https://example.com/hook","events":["created","done"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	created := decode(t, w)
+	id := created["id"]
+	assert.Len(t, created["secret"], 64)
+
+	w = serve(service.Webhooks, http.MethodPost, "/api/webhooks",
+		`{"url":"https://example.com/other","events":["deleted"],"secret":"given"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "given", decode(t, w)["secret"])
+
+	w = serve(service.GetWebhooks, http.MethodGet, "/api/webhooks", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Webhooks []daterules.Webhook `json:"webhooks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Webhooks, 2)
+	assert.Equal(t, []string{"created", "done"}, list.Webhooks[0].Events)
+	assert.Empty(t, list.Webhooks[0].Secret)
+	assert.NotContains(t, w.Body.String(), "given")
+
+	// Creating a task queues it for the first webhook only.
+	task := addTestTask(t, service, `{"date":"`+time.Now().Format(TimeFormat)+`","title":"Собрать релиз"}`)
+
+	deliveries := func(id string) []daterules.Delivery {
+		w := serve(service.WebhookDeliveries, http.MethodGet, "/api/webhooks/deliveries?id="+id, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var log struct {
+			Deliveries []daterules.Delivery `json:"deliveries"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &log))
+		return log.Deliveries
+	}
+	log := deliveries(id)
+	require.Len(t, log, 1)
+	assert.Equal(t, database.DeliveryPending, log[0].Status)
+	assert.Equal(t, EventCreated, log[0].Event)
+	var payload webhook.Payload
+	require.NoError(t, json.Unmarshal([]byte(log[0].Payload), &payload))
+	assert.Equal(t, EventCreated, payload.Event)
+	assert.Contains(t, string(payload.Data), `"id":"`+task+`"`)
+	assert.Contains(t, string(payload.Data), "Собрать релиз")
+
+	w = serve(service.DeleteWebhook, http.MethodDelete, "/api/webhooks?id="+id, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(service.DeleteWebhook, http.MethodDelete, "/api/webhooks?id="+id, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serve(service.WebhookDeliveries, http.MethodGet, "/api/webhooks/deliveries?id="+id, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"final/auth"
 	"final/database"
 	"final/handler"
 
@@ -17,22 +24,126 @@ func main() {
 	r := chi.NewRouter()
 	database.DBInit()
 
-	db, err := sql.Open("sqlite3", "scheduler.db")
+	db, err := database.Open("scheduler.db")
 	if err != nil {
 		panic(err)
 	}
 	defer db.Close()
 
 	store := database.NewContainer(db)
-	service := handler.NewTaskService(store)
+	service := handler.NewTaskService(store, handler.Config{
+		TokenSecret:       tokenSecret(store),
+		UndoWindow:        envDuration("TODO_UNDO_WINDOW", 15*time.Minute),
+		RequireIfMatch:    os.Getenv("TODO_REQUIRE_IF_MATCH") == "true",
+		Password:          os.Getenv("TODO_PASSWORD"),
+		TokenTTL:          envDuration("TODO_TOKEN_TTL", 8*time.Hour),
+		RequireAuth:       os.Getenv("TODO_PASSWORD") != "" || os.Getenv("TODO_REQUIRE_AUTH") == "true",
+		AllowRegistration: os.Getenv("TODO_ALLOW_REGISTRATION") == "true",
+
+		IPRate:          envFloat("TODO_RATE_LIMIT", 50),
+		IPBurst:         envInt("TODO_RATE_BURST", 200),
+		AccountRate:     envFloat("TODO_ACCOUNT_RATE_LIMIT", 20),
+		AccountBurst:    envInt("TODO_ACCOUNT_RATE_BURST", 100),
+		LoginAttempts:   envInt("TODO_LOGIN_ATTEMPTS", 5),
+		LockoutDuration: envDuration("TODO_LOCKOUT", 15*time.Minute),
+		IdempotencyTTL:  envDuration("TODO_IDEMPOTENCY_TTL", 24*time.Hour),
+		Heartbeat:       envDuration("TODO_EVENTS_HEARTBEAT", 15*time.Second),
+		EventHistory:    envInt("TODO_EVENTS_HISTORY", 1000),
+		WebhookAttempts: envInt("TODO_WEBHOOK_ATTEMPTS", 8),
+		WebhookBackoff:  envDuration("TODO_WEBHOOK_BACKOFF", 30*time.Second),
+		WebhookAllow:    envPrefixes("TODO_WEBHOOK_ALLOW"),
+	})
+
+	go purgeTrash(store, envDuration("TODO_TRASH_RETENTION", 30*24*time.Hour))
+	go service.DeliverWebhooks(context.Background())
 
 	fmt.Println("Starting server at port 7540")
 
-	r.Handle("/*", http.FileServer(http.Dir("./web")))
-	r.HandleFunc("/api/task/done", service.DoneTask)
-	r.HandleFunc("/api/task", service.Task)
-	r.HandleFunc("/api/nextdate", handler.NextDeadLine)
-	r.HandleFunc("/api/tasks", service.GetTasks)
+	if os.Getenv("TODO_COMPAT_ERRORS") == "true" {
+		r.Use(handler.CompatErrors)
+	}
+	r.Use(service.RateLimit)
+	r.MethodNotAllowed(handler.MethodNotAllowed)
+
+	web := http.FileServer(http.Dir("./web"))
+	r.Get("/*", web.ServeHTTP)
+	r.Head("/*", web.ServeHTTP)
+
+	// Everything under /api answers in JSON, unknown paths included, and
+	// never falls through to the web files.
+	r.Route("/api", func(r chi.Router) {
+		r.NotFound(handler.NotFound)
+		r.MethodNotAllowed(handler.MethodNotAllowed)
+
+		r.Get("/nextdate", handler.NextDeadLine)
+		r.Get("/openapi.json", handler.OpenAPI)
+		r.Get("/calendar.ics", service.Calendar)
+		r.Post("/signin", service.Signin)
+		r.Post("/register", service.Register)
+		r.Post("/login", service.Login)
+
+		r.Group(func(r chi.Router) {
+			r.Use(service.Auth)
+			r.Use(service.AccountRateLimit)
+
+			r.Post("/task/done", service.DoneTask)
+			r.Post("/task/move", service.MoveTask)
+			r.Get("/task/checklist", service.GetChecklist)
+			r.Post("/task/checklist", service.Checklist)
+			r.Delete("/task/checklist", service.DeleteChecklistItem)
+			r.Post("/task/checklist/toggle", service.ToggleChecklistItem)
+			r.Post("/task/checklist/order", service.ReorderChecklist)
+			r.Post("/task/depends", service.Dependencies)
+			r.Delete("/task/depends", service.DeleteDependency)
+			r.Get("/task/history", service.TaskHistory)
+			r.Get("/task", service.GetTaskByID)
+			r.Post("/task", service.CreateTask)
+			r.Put("/task", service.UpdateTask)
+			r.Patch("/task", service.PatchTask)
+			r.Delete("/task", service.DeleteTask)
+			r.Get("/tasks", service.GetTasks)
+			r.Get("/tasks/actionable", service.ActionableTasks)
+			r.Post("/tasks/batch", service.BatchTasks)
+			r.Get("/events", service.Events)
+			r.Get("/ws", service.Socket)
+			r.Get("/projects", service.GetProjects)
+			r.Post("/projects", service.Projects)
+			r.Put("/projects", service.Projects)
+			r.Delete("/projects", service.DeleteProject)
+			r.Get("/projects/members", service.GetMembers)
+			r.Post("/projects/members", service.Members)
+			r.Delete("/projects/members", service.DeleteMember)
+			r.Get("/history", service.RecentHistory)
+			r.Get("/trash", service.Trash)
+			r.Delete("/trash", service.PurgeTrash)
+			r.Post("/trash/restore", service.RestoreTask)
+			r.Get("/undo", service.GetUndoList)
+			r.Post("/undo", service.Undo)
+			r.Get("/audit", service.Audit)
+			r.Get("/tokens", service.GetAPITokens)
+			r.Post("/tokens", service.APITokens)
+			r.Delete("/tokens", service.DeleteAPIToken)
+			r.Get("/webhooks", service.GetWebhooks)
+			r.Post("/webhooks", service.Webhooks)
+			r.Delete("/webhooks", service.DeleteWebhook)
+			r.Get("/webhooks/deliveries", service.WebhookDeliveries)
+			r.Get("/calendar/feeds", service.GetCalendarFeeds)
+			r.Post("/calendar/feeds", service.CalendarFeeds)
+			r.Delete("/calendar/feeds", service.DeleteCalendarFeed)
+
+			r.Route("/v1", func(r chi.Router) {
+				r.Get("/tasks", service.GetTasks)
+				r.Post("/tasks", service.CreateTaskV1)
+				r.Get("/tasks/actionable", service.ActionableTasks)
+				r.Post("/tasks/batch", service.BatchTasks)
+				r.Get("/tasks/{id}", service.GetTaskV1)
+				r.Put("/tasks/{id}", service.UpdateTaskV1)
+				r.Patch("/tasks/{id}", service.PatchTaskV1)
+				r.Delete("/tasks/{id}", service.DeleteTaskV1)
+				r.Post("/tasks/{id}/done", service.DoneTaskV1)
+			})
+		})
+	})
 
 	err = http.ListenAndServe(":7540", r)
 	if err != nil {
@@ -40,3 +151,91 @@ func main() {
 	}
 
 }
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	env := os.Getenv(name)
+	if env == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(env)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func envFloat(name string, fallback float64) float64 {
+	env := os.Getenv(name)
+	if env == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(env, 64)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func envInt(name string, fallback int) int {
+	env := os.Getenv(name)
+	if env == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(env)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+// envPrefixes reads a comma-separated list of networks, such as
+// "10.0.0.0/8,192.168.1.5/32".
+func envPrefixes(name string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			panic(err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// tokenSecret returns the key tokens are signed with: TODO_TOKEN_SECRET,
+// or a random key generated on the first start and kept in the database.
+func tokenSecret(store database.TaskContainer) []byte {
+	if env := os.Getenv("TODO_TOKEN_SECRET"); env != "" {
+		if len(env) < 32 {
+			panic("TODO_TOKEN_SECRET must be at least 32 characters long")
+		}
+		return []byte(env)
+	}
+	key, err := auth.NewKey()
+	if err != nil {
+		panic(err)
+	}
+	secret, err := store.Secret("token", key)
+	if err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+func purgeTrash(store database.TaskContainer, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		count, err := store.PurgeExpired(time.Now().Add(-retention))
+		if err != nil {
+			log.Println("trash purge failed:", err)
+		} else if count > 0 {
+			log.Printf("purged %d tasks from the trash", count)
+		}
+		<-ticker.C
+	}
+}
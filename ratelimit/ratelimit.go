@@ -0,0 +1,151 @@
+// Package ratelimit throttles requests with token buckets and locks out
+// the accounts with too many failed sign-ins.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxKeys is the number of keys after which the state of idle keys is
+// dropped.
+const maxKeys = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket per key. A bucket holds up to burst tokens
+// and gains rate tokens per second.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty it
+// returns false and the time until the next token. A limiter with a zero
+// rate allows everything.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxKeys {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets that have refilled completely.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type failures struct {
+	count int
+	until time.Time
+}
+
+// Lockout blocks a key for a while after a number of failures in a row.
+type Lockout struct {
+	mu       sync.Mutex
+	attempts int
+	duration time.Duration
+	keys     map[string]*failures
+	now      func() time.Time
+}
+
+func NewLockout(attempts int, duration time.Duration) *Lockout {
+	return &Lockout{
+		attempts: attempts,
+		duration: duration,
+		keys:     make(map[string]*failures),
+		now:      time.Now,
+	}
+}
+
+// Locked returns how long the key stays locked, or zero if it is not.
+func (l *Lockout) Locked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.keys[key]
+	if !ok {
+		return 0
+	}
+	return max(f.until.Sub(l.now()), 0)
+}
+
+// Fail counts a failure of the key and locks it once there are too many.
+// A lockout with no attempts never locks.
+func (l *Lockout) Fail(key string) {
+	if l.attempts <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	f, ok := l.keys[key]
+	if !ok {
+		if len(l.keys) >= maxKeys {
+			for k, f := range l.keys {
+				if !f.until.After(now) {
+					delete(l.keys, k)
+				}
+			}
+		}
+		f = &failures{}
+		l.keys[key] = f
+	}
+
+	f.count++
+	if f.count >= l.attempts {
+		f.count = 0
+		f.until = now.Add(l.duration)
+	}
+}
+
+// Reset forgets the failures of the key after a success.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.keys, key)
+}
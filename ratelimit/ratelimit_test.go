@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestLimiter(t *testing.T) {
+	c := &clock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewLimiter(2, 3)
+	l.now = c.Now
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok, i)
+	}
+	ok, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	c.now = c.now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	c.now = c.now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok, i)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	c := &clock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewLockout(3, time.Minute)
+	l.now = c.Now
+
+	l.Fail("ann")
+	l.Fail("ann")
+	assert.Zero(t, l.Locked("ann"))
+	l.Reset("ann")
+	l.Fail("ann")
+	l.Fail("ann")
+	assert.Zero(t, l.Locked("ann"))
+	l.Fail("ann")
+	assert.Equal(t, time.Minute, l.Locked("ann"))
+	assert.Zero(t, l.Locked("bob"))
+
+	c.now = c.now.Add(40 * time.Second)
+	assert.Equal(t, 20*time.Second, l.Locked("ann"))
+	c.now = c.now.Add(20 * time.Second)
+	assert.Zero(t, l.Locked("ann"))
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"database/sql"
 	"os"
 	"testing"
 	"time"
@@ -11,11 +12,18 @@ import (
 )
 
 type Task struct {
-	ID      int64  `db:"id"`
-	Date    string `db:"date"`
-	Title   string `db:"title"`
-	Comment string `db:"comment"`
-	Repeat  string `db:"repeat"`
+	ID        int64          `db:"id"`
+	Date      string         `db:"date"`
+	Title     string         `db:"title"`
+	Comment   string         `db:"comment"`
+	Repeat    string         `db:"repeat"`
+	ProjectID int64          `db:"project_id"`
+	DeletedAt sql.NullString `db:"deleted_at"`
+	Version   int64          `db:"version"`
+	CreatedAt sql.NullString `db:"created_at"`
+	UpdatedAt sql.NullString `db:"updated_at"`
+	UserID    int64          `db:"user_id"`
+	DoneAt    sql.NullString `db:"done_at"`
 }
 
 func count(db *sqlx.DB) (int, error) {
@@ -0,0 +1,303 @@
+// Package webhook posts the task events to the URLs users subscribed them
+// to. The events are queued in the database and delivered by a background
+// worker, which retries failed deliveries with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"final/database"
+	"final/daterules"
+	"final/events"
+)
+
+// Headers of a delivery. The signature is "sha256=" followed by the hex
+// HMAC-SHA256 of the body keyed with the secret of the webhook.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Defaults used for the zero fields of Config.
+const (
+	defaultAttempts = 8
+	defaultBackoff  = 30 * time.Second
+	defaultTimeout  = 10 * time.Second
+	defaultPoll     = 5 * time.Second
+
+	maxBackoff = 24 * time.Hour
+	retention  = 30 * 24 * time.Hour
+	batchSize  = 50
+)
+
+type Config struct {
+	// Attempts is how many times a delivery is tried before it fails.
+	Attempts int
+	// Backoff is the delay before the first retry; it doubles with every
+	// attempt.
+	Backoff time.Duration
+	// Timeout limits a single request.
+	Timeout time.Duration
+	// Poll is how often the queue is checked for retries that came due.
+	Poll time.Duration
+	// Allow lists the networks deliveries may reach even though they are
+	// not public, such as a trusted service in the local network.
+	Allow []netip.Prefix
+}
+
+// ErrBlockedAddress is returned for a webhook whose host resolves to a
+// loopback, private, link-local or otherwise internal address, so that
+// users cannot make the server call its own network or the metadata
+// service of the cloud it runs in. Such a delivery is not retried.
+var ErrBlockedAddress = errors.New("webhook address is not public")
+
+// reserved are the internal ranges netip.Addr has no predicate for.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// blocked reports whether a delivery may not connect to the address.
+func blocked(addr netip.Addr, allow []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range allow {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range reserved {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the body posted to a webhook.
+type Payload struct {
+	Event     string          `json:"event"`
+	EventID   int64           `json:"event_id"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type Worker struct {
+	store  database.TaskContainer
+	config Config
+	client *http.Client
+	wake   chan struct{}
+	now    func() time.Time
+}
+
+func NewWorker(store database.TaskContainer, config Config) *Worker {
+	if config.Attempts <= 0 {
+		config.Attempts = defaultAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.Poll <= 0 {
+		config.Poll = defaultPoll
+	}
+	// The address is checked after the host is resolved, right before
+	// connecting, so a DNS name cannot point somewhere else than it did
+	// when checked. There is no proxy, which would be dialed instead.
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if blocked(addrPort.Addr(), config.Allow) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+	return &Worker{
+		store:  store,
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				ForceAttemptHTTP2:   true,
+				MaxIdleConns:        10,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			// A redirect counts as a failure: the signed body is not
+			// sent to a URL the user did not register.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+		now:  time.Now,
+	}
+}
+
+// Sign returns the signature of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enqueue queues the event for the webhooks of the users allowed to see
+// it and wakes the worker.
+func (w *Worker) Enqueue(e events.Event) error {
+	body, err := json.Marshal(Payload{
+		Event:     e.Type,
+		EventID:   e.ID,
+		CreatedAt: w.now().UTC().Format(time.RFC3339),
+		Data:      e.Data,
+	})
+	if err != nil {
+		return err
+	}
+	count, err := w.store.AddDeliveries(e.Users, e.Type, e.ID, string(body))
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run delivers the queued events until the context is done.
+func (w *Worker) Run(ctx context.Context) {
+	var pruned time.Time
+	for {
+		w.deliverDue()
+		if now := w.now(); now.Sub(pruned) > time.Hour {
+			if err := w.store.PruneDeliveries(now.Add(-retention)); err != nil {
+				log.Println("webhook deliveries prune failed:", err)
+			}
+			pruned = now
+		}
+
+		timer := time.NewTimer(w.config.Poll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-w.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// deliverDue tries every delivery that is due and returns how many it
+// tried.
+func (w *Worker) deliverDue() int {
+	tried := 0
+	for {
+		due, err := w.store.DueDeliveries(w.now(), batchSize)
+		if err != nil {
+			log.Println("webhook deliveries not loaded:", err)
+			return tried
+		}
+		for _, d := range due {
+			if err := w.store.SaveDelivery(w.deliver(d)); err != nil {
+				log.Println("webhook delivery", d.ID, "not saved:", err)
+			}
+		}
+		tried += len(due)
+		if len(due) < batchSize {
+			return tried
+		}
+	}
+}
+
+// deliver makes one attempt and returns the delivery updated with its
+// outcome.
+func (w *Worker) deliver(d daterules.Delivery) daterules.Delivery {
+	d.Attempts++
+	d.ResponseCode = 0
+	d.Error = ""
+
+	code, err := w.post(d)
+	d.ResponseCode = code
+	now := w.now().UTC()
+	switch {
+	case err == nil:
+		d.Status = database.DeliveryDelivered
+		d.DeliveredAt = now.Format(time.RFC3339)
+		return d
+	case errors.Is(err, ErrBlockedAddress) || d.Attempts >= w.config.Attempts:
+		d.Status = database.DeliveryFailed
+	default:
+		d.NextAttemptAt = now.Add(w.backoff(d.Attempts)).Format(time.RFC3339)
+	}
+	d.Error = err.Error()
+	return d
+}
+
+func (w *Worker) post(d daterules.Delivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("User-Agent", "todo-webhook")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(SignatureHeader, Sign(d.Secret, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff is the delay after the given number of failed attempts.
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.config.Backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
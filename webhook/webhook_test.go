@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"final/database"
+	"final/daterules"
+	"final/events"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver records the requests it gets and answers them with the next of
+// the codes, repeating the last one.
+func receiver(t *testing.T, codes ...int) (string, func() []received) {
+	var mu sync.Mutex
+	var got []received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, received{header: r.Header, body: body})
+		code := codes[min(len(got), len(codes))-1]
+		mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), got...)
+	}
+}
+
+func newTestWorker(t *testing.T, config Config) (*Worker, database.TaskContainer, *time.Time) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "scheduler.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, database.Migrate(db))
+
+	// The receivers of the tests listen on the loopback interface.
+	config.Allow = append(config.Allow, netip.MustParsePrefix("127.0.0.0/8"))
+	store := database.NewContainer(db)
+	w := NewWorker(store, config)
+	now := time.Now().UTC().Truncate(time.Second)
+	w.now = func() time.Time { return now }
+	return w, store, &now
+}
+
+func addHook(t *testing.T, store database.TaskContainer, user int64, url string, events ...string) string {
+	id, err := store.ForUser(user).AddWebhook(daterules.Webhook{URL: url, Events: events, Secret: "s3cret"})
+	require.NoError(t, err)
+	return strconv.FormatInt(id, 10)
+}
+
+func TestDeliver(t *testing.T) {
+	url, got := receiver(t, http.StatusNoContent)
+	w, store, _ := newTestWorker(t, Config{})
+	hook := addHook(t, store, 1, url, "created", "done")
+	other := addHook(t, store, 2, url, "created")
+	addHook(t, store, 3, url, "created")
+
+	require.NoError(t, w.Enqueue(events.Event{ID: 7, Type: "created", Data: json.RawMessage(`{"id":"5"}`), Users: []int64{1, 2}}))
+	require.NoError(t, w.Enqueue(events.Event{ID: 8, Type: "updated", Users: []int64{1, 2}}))
+	assert.Equal(t, 2, w.deliverDue())
+	assert.Equal(t, 0, w.deliverDue())
+
+	requests := got()
+	require.Len(t, requests, 2)
+	for _, req := range requests {
+		assert.Equal(t, Sign("s3cret", req.body), req.header.Get(SignatureHeader))
+		assert.Equal(t, "created", req.header.Get(EventHeader))
+		assert.NotEmpty(t, req.header.Get(DeliveryHeader))
+
+		var payload Payload
+		require.NoError(t, json.Unmarshal(req.body, &payload))
+		assert.Equal(t, "created", payload.Event)
+		assert.Equal(t, int64(7), payload.EventID)
+		assert.JSONEq(t, `{"id":"5"}`, string(payload.Data))
+	}
+
+	for _, id := range []string{hook, other} {
+		user := int64(1)
+		if id == other {
+			user = 2
+		}
+		deliveries, err := store.ForUser(user).GetDeliveries(id, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, database.DeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusNoContent, deliveries[0].ResponseCode)
+		assert.NotEmpty(t, deliveries[0].DeliveredAt)
+	}
+
+	// The log of a webhook is only shown to its owner.
+	deliveries, err := store.ForUser(2).GetDeliveries(hook, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestRetry(t *testing.T) {
+	url, got := receiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	w, store, now := newTestWorker(t, Config{Attempts: 5, Backoff: time.Minute})
+	hook := addHook(t, store, 0, url, "done")
+	require.NoError(t, w.Enqueue(events.Event{ID: 1, Type: "done", Users: []int64{0}}))
+
+	log := func() daterules.Delivery {
+		deliveries, err := store.GetDeliveries(hook, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		return deliveries[0]
+	}
+
+	assert.Equal(t, 1, w.deliverDue())
+	d := log()
+	assert.Equal(t, database.DeliveryPending, d.Status)
+	assert.Equal(t, http.StatusInternalServerError, d.ResponseCode)
+	assert.Contains(t, d.Error, "500")
+	assert.Equal(t, now.Add(time.Minute).Format(time.RFC3339), d.NextAttemptAt)
+
+	// Not due before the backoff has passed.
+	*now = now.Add(59 * time.Second)
+	assert.Equal(t, 0, w.deliverDue())
+	*now = now.Add(time.Second)
+	assert.Equal(t, 1, w.deliverDue())
+	d = log()
+	assert.Equal(t, 2, d.Attempts)
+	assert.Equal(t, now.Add(2*time.Minute).Format(time.RFC3339), d.NextAttemptAt)
+
+	*now = now.Add(2 * time.Minute)
+	assert.Equal(t, 1, w.deliverDue())
+	d = log()
+	assert.Equal(t, database.DeliveryDelivered, d.Status)
+	assert.Equal(t, 3, d.Attempts)
+	assert.Empty(t, d.Error)
+	assert.Len(t, got(), 3)
+}
+
+func TestGiveUp(t *testing.T) {
+	url, got := receiver(t, http.StatusFound)
+	w, store, now := newTestWorker(t, Config{Attempts: 2, Backoff: time.Second})
+	hook := addHook(t, store, 0, url, "deleted")
+	require.NoError(t, w.Enqueue(events.Event{ID: 1, Type: "deleted", Users: []int64{0}}))
+
+	for i := 0; i < 3; i++ {
+		w.deliverDue()
+		*now = now.Add(time.Hour)
+	}
+	deliveries, err := store.GetDeliveries(hook, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, database.DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusFound, deliveries[0].ResponseCode)
+	assert.Empty(t, deliveries[0].NextAttemptAt)
+	assert.Len(t, got(), 2)
+
+	// Finished deliveries are pruned once old enough.
+	require.NoError(t, store.PruneDeliveries(now.Add(time.Hour)))
+	deliveries, err = store.GetDeliveries(hook, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestBackoff(t *testing.T) {
+	w := NewWorker(database.TaskContainer{}, Config{Backoff: time.Minute})
+	assert.Equal(t, time.Minute, w.backoff(1))
+	assert.Equal(t, 8*time.Minute, w.backoff(4))
+	assert.Equal(t, maxBackoff, w.backoff(100))
+}
+
+func TestRun(t *testing.T) {
+	url, got := receiver(t, http.StatusOK)
+	w, store, _ := newTestWorker(t, Config{Poll: time.Hour})
+	w.now = time.Now
+	addHook(t, store, 0, url, "created")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Enqueue wakes the worker long before the next poll.
+	require.NoError(t, w.Enqueue(events.Event{ID: 1, Type: "created", Users: []int64{0}}))
+	assert.Eventually(t, func() bool { return len(got()) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestBlockedAddress(t *testing.T) {
+	url, got := receiver(t, http.StatusOK)
+	_, store, _ := newTestWorker(t, Config{})
+	w := NewWorker(store, Config{})
+	hook := addHook(t, store, 1, url, "created")
+
+	require.NoError(t, w.Enqueue(events.Event{ID: 1, Type: "created", Users: []int64{1}}))
+	assert.Equal(t, 1, w.deliverDue())
+	assert.Empty(t, got())
+
+	// An internal address is not retried.
+	deliveries, err := store.ForUser(1).GetDeliveries(hook, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, database.DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Contains(t, deliveries[0].Error, ErrBlockedAddress.Error())
+
+	for addr, want := range map[string]bool{
+		"127.0.0.1":       true,
+		"::1":             true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"fe80::1":         true,
+		"fd00:ec2::254":   true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::":              true,
+		"224.0.0.1":       true,
+		"::ffff:10.0.0.1": true,
+		"93.184.216.34":   false,
+		"2606:4700::1":    false,
+	} {
+		assert.Equal(t, want, blocked(netip.MustParseAddr(addr), nil), addr)
+	}
+	allow := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	assert.False(t, blocked(netip.MustParseAddr("10.1.2.3"), allow))
+	assert.True(t, blocked(netip.MustParseAddr("192.168.1.1"), allow))
+}